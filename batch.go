@@ -0,0 +1,49 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import "io"
+
+// DecodeBatch converts consecutive JSON objects from the input stream into
+// BSON documents appended to the output buffer, until the batch has maxDocs
+// documents or until another document would make the batch larger than
+// maxBytes.  A limit less than one means no limit.  The offset in the output
+// buffer where each document starts is appended to bounds; each document's
+// length is in its first four bytes.  The final buffer and bounds are
+// returned, just like with `append`.
+//
+// A document that would go over maxBytes is held by the decoder and returned
+// first by the next call to DecodeBatch, Decode or DecodeValue.  To ensure
+// progress, a document larger than maxBytes by itself is returned as a batch
+// of one.
+//
+// If Decode returns an error, the documents already in the batch are returned
+// with it.  The function returns io.EOF only if no objects remain in the
+// stream, so a final batch ending at the end of the stream returns no error.
+func (d *Decoder) DecodeBatch(buf []byte, bounds []int, maxDocs int, maxBytes int) ([]byte, []int, error) {
+	batchStart := len(buf)
+	for n := 0; maxDocs < 1 || n < maxDocs; n++ {
+		start := len(buf)
+		out, err := d.Decode(buf)
+		if err != nil {
+			if err == io.EOF && n > 0 {
+				err = nil
+			}
+			return buf, bounds, err
+		}
+
+		if maxBytes > 0 && n > 0 && len(out)-batchStart > maxBytes {
+			d.held = append(d.held[0:0], out[start:]...)
+			return out[0:start], bounds, nil
+		}
+
+		buf = out
+		bounds = append(bounds, start)
+	}
+
+	return buf, bounds, nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// batchJSON converts the documents in a batch back to JSON.
+func batchJSON(t *testing.T, buf []byte, bounds []int) []string {
+	t.Helper()
+	docs := make([]string, 0, len(bounds))
+	for i, start := range bounds {
+		end := len(buf)
+		if i+1 < len(bounds) {
+			end = bounds[i+1]
+		}
+		if length := int(binary.LittleEndian.Uint32(buf[start:])); start+length != end {
+			t.Fatalf("document %d has length %d, but bounds are %d to %d", i, length, start, end)
+		}
+		json, err := Marshal(buf[start:end], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, string(json))
+	}
+	return docs
+}
+
+// TestDecodeBatch checks batches limited by document count and size.
+func TestDecodeBatch(t *testing.T) {
+	t.Parallel()
+
+	// Each document is 12 bytes, except the 29 byte one.
+	input := `{"a":1} {"a":2} {"a":3} {"long":"xxxxxxxxxxxxx"} {"a":5}`
+
+	type testCase struct {
+		label    string
+		maxDocs  int
+		maxBytes int
+		batches  [][]string
+	}
+
+	cases := []testCase{
+		{
+			label:   "count",
+			maxDocs: 2,
+			batches: [][]string{
+				{`{"a":1}`, `{"a":2}`},
+				{`{"a":3}`, `{"long":"xxxxxxxxxxxxx"}`},
+				{`{"a":5}`},
+			},
+		},
+		{
+			label:    "bytes",
+			maxBytes: 30,
+			batches: [][]string{
+				{`{"a":1}`, `{"a":2}`},
+				{`{"a":3}`},
+				{`{"long":"xxxxxxxxxxxxx"}`},
+				{`{"a":5}`},
+			},
+		},
+		{
+			label:    "oversized document",
+			maxBytes: 20,
+			batches: [][]string{
+				{`{"a":1}`},
+				{`{"a":2}`},
+				{`{"a":3}`},
+				{`{"long":"xxxxxxxxxxxxx"}`},
+				{`{"a":5}`},
+			},
+		},
+		{
+			label:    "count and bytes",
+			maxDocs:  2,
+			maxBytes: 100,
+			batches: [][]string{
+				{`{"a":1}`, `{"a":2}`},
+				{`{"a":3}`, `{"long":"xxxxxxxxxxxxx"}`},
+				{`{"a":5}`},
+			},
+		},
+		{
+			label: "unlimited",
+			batches: [][]string{
+				{`{"a":1}`, `{"a":2}`, `{"a":3}`, `{"long":"xxxxxxxxxxxxx"}`, `{"a":5}`},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Reuse buffers, keeping a prefix to check that bounds are
+			// offsets in the whole buffer.
+			prefix := []byte("prefix")
+			var buf []byte
+			var bounds []int
+			batches := make([][]string, 0)
+			for {
+				buf, bounds, err = jib.DecodeBatch(append(buf[0:0], prefix...), bounds[0:0], c.maxDocs, c.maxBytes)
+				if err != nil {
+					break
+				}
+				if string(buf[0:len(prefix)]) != string(prefix) {
+					t.Fatalf("prefix was overwritten: %q", buf)
+				}
+				batches = append(batches, batchJSON(t, buf, bounds))
+			}
+			if err != io.EOF {
+				t.Fatalf("expected io.EOF, but got %v", err)
+			}
+			if len(bounds) != 0 {
+				t.Errorf("expected no documents with io.EOF, but got %d", len(bounds))
+			}
+			if !reflect.DeepEqual(batches, c.batches) {
+				t.Errorf("expected batches %q, but got %q", c.batches, batches)
+			}
+		})
+	}
+}
+
+// TestDecodeBatchHeld checks that Decode and DecodeValue return a document held
+// back by DecodeBatch.
+func TestDecodeBatchHeld(t *testing.T) {
+	t.Parallel()
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(`{"a":1} {"b":2} {"c":3}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, bounds, err := jib.DecodeBatch(nil, nil, 0, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := batchJSON(t, buf, bounds); !reflect.DeepEqual(got, []string{`{"a":1}`}) {
+		t.Errorf("unexpected batch %q", got)
+	}
+	for _, expect := range []string{`{"b":2}`, `{"c":3}`} {
+		buf, err = jib.Decode(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json, err := Marshal(buf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(json) != expect {
+			t.Errorf("expected %s, but got %s", expect, json)
+		}
+	}
+
+	jib.Reset(strings.NewReader(`{"a":1} {"b":2} 3`))
+	_, _, err = jib.DecodeBatch(nil, nil, 0, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{`03{"b":2}`, `10`} {
+		bsonType, buf, err := jib.DecodeValue(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := fmt.Sprintf("%02X", bsonType)
+		if bsonType == bsonDocument {
+			json, err := Marshal(buf, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got += string(json)
+		}
+		if got != expect {
+			t.Errorf("expected %s, but got %s", expect, got)
+		}
+	}
+}
+
+// TestDecodeBatchError checks that documents before an error are returned and
+// that decoding can continue with error recovery.
+func TestDecodeBatchError(t *testing.T) {
+	t.Parallel()
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader("{\"a\":1}\n{\"a\":}\n{\"a\":3}\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jib.Recover(true)
+
+	buf, bounds, err := jib.DecodeBatch(nil, nil, 10, 0)
+	if _, ok := err.(*ParseError); !ok {
+		t.Fatalf("expected ParseError, but got %v", err)
+	}
+	if got := batchJSON(t, buf, bounds); !reflect.DeepEqual(got, []string{`{"a":1}`}) {
+		t.Errorf("unexpected batch before error %q", got)
+	}
+
+	buf, bounds, err = jib.DecodeBatch(nil, nil, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := batchJSON(t, buf, bounds); !reflect.DeepEqual(got, []string{`{"a":3}`}) {
+		t.Errorf("unexpected batch after error %q", got)
+	}
+}
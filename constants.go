@@ -17,6 +17,9 @@ var emptyDoc = []byte{5, 0, 0, 0, 0}
 // because top level values don't need a type byte to be written later.
 const topContainer = -1
 
+// MongoDB's maximum BSON document size.
+const defaultMaxDocumentSize = 16 * 1024 * 1024
+
 // Unicode byte order marks.
 var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
 var utf16LEBOM = []byte{0xFF, 0xFE}
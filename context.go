@@ -0,0 +1,142 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// contextCheckInterval is how many object or array elements are converted
+// between checks of the context of DecodeContext.
+const contextCheckInterval = 1024
+
+// DecodeContext is like Decode, but returns ctx.Err() if the context is done
+// before the next document starts, or while converting a large document.
+// Inside a document, the context is checked every 1024 object or array
+// elements, so a document with few elements, like one with a single huge
+// string or number, is converted without interruption.  It can't interrupt a
+// read of the input that blocks; for that, use a reader with a deadline or
+// close the reader.
+//
+// If the context is done before the next document, the decoder can continue
+// with that document.  If it is done inside a document, the rest of the
+// document remains in the input, so the decoder must be Reset before it is
+// used again.
+func (d *Decoder) DecodeContext(ctx context.Context, buf []byte) ([]byte, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// A context that can't be done needn't be checked.
+	if ctx.Done() != nil {
+		d.ctx = ctx
+		defer func() { d.ctx = nil }()
+	}
+	return d.Decode(buf)
+}
+
+// checkContext returns the error of the context of DecodeContext if it is
+// done, checking only once per contextCheckInterval calls.
+func (d *Decoder) checkContext() error {
+	if d.ctx == nil {
+		return nil
+	}
+	d.ctxCount++
+	if d.ctxCount < contextCheckInterval {
+		return nil
+	}
+	d.ctxCount = 0
+	return d.ctx.Err()
+}
+
+// DocumentIterator iterates over the documents in the input stream of a
+// Decoder until the input ends, an error occurs or a context is done.  If the
+// Decoder has error recovery enabled, the malformed or oversized documents
+// that it skips don't stop the iterator, and their errors are available from
+// Skipped.
+//
+//	iter := jib.Documents(ctx)
+//	for iter.Next() {
+//		doc := iter.Document()
+//		...
+//	}
+//	if err := iter.Err(); err != nil {
+//		...
+//	}
+type DocumentIterator struct {
+	d       *Decoder
+	ctx     context.Context
+	buf     []byte
+	skipped []error
+	done    bool
+	err     error
+}
+
+// Documents returns an iterator that converts each document in the input
+// stream with DecodeContext.
+func (d *Decoder) Documents(ctx context.Context) *DocumentIterator {
+	return &DocumentIterator{d: d, ctx: ctx}
+}
+
+// Next converts the next document, reusing the buffer of the previous one.
+// It returns false when the input ends, the context is done or an error
+// occurs that error recovery doesn't skip.
+func (it *DocumentIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	it.skipped = it.skipped[0:0]
+	for {
+		buf, err := it.d.DecodeContext(it.ctx, it.buf[0:0])
+		if err == nil {
+			it.buf = buf
+			return true
+		}
+		if it.d.recovered(err) {
+			it.skipped = append(it.skipped, err)
+			continue
+		}
+		it.done = true
+		if err != io.EOF {
+			it.err = err
+		}
+		return false
+	}
+}
+
+// recovered returns true if error recovery has skipped the top-level value
+// with an error, so Decode can continue with the next one.
+func (d *Decoder) recovered(err error) bool {
+	if !d.recoverErrors {
+		return false
+	}
+	var pe *ParseError
+	var se *DocumentSizeError
+	return errors.As(err, &pe) || errors.As(err, &se)
+}
+
+// Document returns the BSON document converted by the last call to Next.  It
+// is only valid until the next call to Next.
+func (it *DocumentIterator) Document() []byte {
+	return it.buf
+}
+
+// Skipped returns the errors of the documents that error recovery skipped
+// during the last call to Next, before the document it converted or the end
+// of the input.  It is only valid until the next call to Next.
+func (it *DocumentIterator) Skipped() []error {
+	return it.skipped
+}
+
+// Err returns the error that stopped the iterator, which is ctx.Err() if the
+// context is done, or nil if the input ended.
+func (it *DocumentIterator) Err() error {
+	return it.err
+}
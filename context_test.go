@@ -0,0 +1,183 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cancelReader cancels a context when it is read after its first read.
+type cancelReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+	n      int
+}
+
+func (c *cancelReader) Read(b []byte) (int, error) {
+	c.n++
+	if c.n > 1 {
+		c.cancel()
+	}
+	return c.r.Read(b)
+}
+
+func TestDecodeContext(t *testing.T) {
+	t.Parallel()
+
+	// A done context stops before the next document, which can still be
+	// decoded.
+	jib := NewDecoderFromReader(strings.NewReader(`{"a":1} {"a":2}`))
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := jib.DecodeContext(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	_, err = jib.DecodeContext(ctx, nil)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, but got %v", err)
+	}
+	buf, err := jib.Decode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, err := MarshalExtJSON(buf, nil, RelaxedExtJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(json) != `{"a":2}` {
+		t.Errorf("expected second document, but got %s", json)
+	}
+
+	// A done context stops inside a large document.
+	input := `{"a":[` + strings.Repeat(`{"b":0},`, 100000) + `0]}`
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	jib = NewDecoderFromReader(&cancelReader{r: strings.NewReader(input), cancel: cancel})
+	_, err = jib.DecodeContext(ctx, nil)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, but got %v", err)
+	}
+
+	// Without a done context, the document is converted.
+	jib.Reset(strings.NewReader(input))
+	_, err = jib.DecodeContext(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDocumentIterator(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label   string
+		input   string
+		cancel  bool
+		recover bool
+		output  []string
+		skipped []int
+		errStr  string
+	}
+
+	cases := []testCase{
+		{
+			label:  "documents",
+			input:  `{"a":1} {"a":2} {"a":3}`,
+			output: []string{`{"a":1}`, `{"a":2}`, `{"a":3}`},
+		},
+		{
+			label:  "empty input",
+			input:  ``,
+			output: []string{},
+		},
+		{
+			label:  "parse error",
+			input:  `{"a":1} {"a":}`,
+			output: []string{`{"a":1}`},
+			errStr: "invalid character",
+		},
+		{
+			label:   "recovered errors",
+			input:   "{\"a\":1}\n{\"a\":}\n{\"a\":2}\n{\"a\":[}\n{\"a\":x}",
+			recover: true,
+			output:  []string{`{"a":1}`, `{"a":2}`},
+			skipped: []int{0, 1, 2},
+		},
+		{
+			label:  "done context",
+			input:  `{"a":1} {"a":2}`,
+			cancel: true,
+			output: []string{},
+			errStr: "context canceled",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if c.cancel {
+				cancel()
+			}
+
+			jib := NewDecoderFromReader(strings.NewReader(c.input))
+			jib.Recover(c.recover)
+			iter := jib.Documents(ctx)
+			output := make([]string, 0)
+			skipped := make([]int, 0)
+			for iter.Next() {
+				skipped = append(skipped, len(iter.Skipped()))
+				json, err := MarshalExtJSON(iter.Document(), nil, RelaxedExtJSON)
+				if err != nil {
+					t.Fatal(err)
+				}
+				output = append(output, string(json))
+			}
+			if iter.Next() {
+				t.Errorf("expected no more documents after the iterator stopped")
+			}
+			if !reflect.DeepEqual(output, c.output) {
+				t.Errorf("expected %q, but got %q", c.output, output)
+			}
+			if c.skipped != nil {
+				skipped = append(skipped, len(iter.Skipped()))
+				if !reflect.DeepEqual(skipped, c.skipped) {
+					t.Errorf("expected skipped error counts %v, but got %v", c.skipped, skipped)
+				}
+				for _, err := range iter.Skipped() {
+					var pe *ParseError
+					if !errors.As(err, &pe) || len(pe.Skipped) == 0 {
+						t.Errorf("expected *ParseError with skipped text, but got %v", err)
+					}
+				}
+			}
+
+			err := iter.Err()
+			if c.errStr == "" {
+				if err != nil {
+					t.Errorf("expected no error, but got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.errStr) {
+				t.Errorf("expected error with '%s', but got %v", c.errStr, err)
+			}
+			if c.cancel && !errors.Is(err, context.Canceled) {
+				t.Errorf("expected context.Canceled, but got %v", err)
+			}
+		})
+	}
+}
@@ -6,8 +6,9 @@
 
 // Package jibby is a high-performance, streaming JSON-to-BSON decoder.  It
 // decodes successive JSON objects into BSON documents from a buffered input
-// byte stream while minimizing memory copies.  Only UTF-8 encoding is supported
-// and input text is expected to be well-formed.
+// byte stream while minimizing memory copies.  UTF-16 and UTF-32 input is
+// transcoded to UTF-8.  Input text is expected to be well-formed, unless the
+// Decoder is set to check strings and keys for invalid UTF-8.
 //
 // Extended JSON
 //
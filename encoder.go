@@ -0,0 +1,333 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Encoder converts BSON documents to JSON objects and writes them to an output
+// stream.  It is the inverse of Decoder.  Each encoded object is followed by a
+// newline, so the output stream can be read back with a Decoder.
+type Encoder struct {
+	buf         []byte
+	curDepth    int
+	extJSONMode ExtJSONMode
+	maxDepth    int
+	w           io.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		buf:      make([]byte, 0, 256),
+		maxDepth: 200,
+		w:        w,
+	}
+}
+
+// MaxDepth sets the maximum allowed depth of a BSON document.  The default is
+// 200.
+func (e *Encoder) MaxDepth(n int) {
+	e.maxDepth = n
+}
+
+// ExtJSON sets how BSON types without a JSON equivalent are encoded.  The
+// default, PlainJSON, makes such types an error.
+// See https://docs.mongodb.com/manual/reference/mongodb-extended-json/index.html
+func (e *Encoder) ExtJSON(mode ExtJSONMode) {
+	e.extJSONMode = mode
+}
+
+// Encode converts a single BSON document into a JSON object and writes it,
+// followed by a newline, to the output stream.  The document must be exactly
+// the length of the byte slice.  The Encoder reuses an internal buffer, so
+// steady-state encoding doesn't allocate.
+func (e *Encoder) Encode(doc []byte) error {
+	buf, err := e.encodeTopDocument(e.buf[0:0], doc)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	e.buf = buf
+
+	_, err = e.w.Write(buf)
+	return err
+}
+
+// encodeTopDocument checks that the document fills the input slice and
+// converts it.
+func (e *Encoder) encodeTopDocument(out []byte, doc []byte) ([]byte, error) {
+	length, err := readDocumentLength(doc)
+	if err != nil {
+		return nil, err
+	}
+	if length != len(doc) {
+		return nil, newBSONError("document length %d doesn't match input length %d", length, len(doc))
+	}
+	out, _, err = e.encodeDocument(out, doc, false)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// encodeDocument starts at the length bytes of a BSON document or array and
+// appends the corresponding JSON object or array to the output.  It returns the
+// number of input bytes consumed.
+func (e *Encoder) encodeDocument(out []byte, in []byte, isArray bool) ([]byte, int, error) {
+	// Depth check
+	e.curDepth++
+	defer func() { e.curDepth-- }()
+	if e.curDepth > e.maxDepth {
+		return nil, 0, errors.New("maximum depth exceeded")
+	}
+
+	length, err := readDocumentLength(in)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	openCh, closeCh := byte('{'), byte('}')
+	if isArray {
+		openCh, closeCh = '[', ']'
+	}
+	out = append(out, openCh)
+
+	// Elements are everything between the length and the null terminator.
+	elements := in[4 : length-1]
+	var i int
+	for i < len(elements) {
+		if i > 0 {
+			out = append(out, ',')
+		}
+
+		bsonType := elements[i]
+		i++
+
+		keyLength := bytes.IndexByte(elements[i:], nullByte)
+		if keyLength < 0 {
+			return nil, 0, newBSONError("unterminated element key")
+		}
+		if !utf8.Valid(elements[i : i+keyLength]) {
+			return nil, 0, newBSONError("element key not valid UTF-8")
+		}
+		if !isArray {
+			out = appendJSONString(out, elements[i:i+keyLength])
+			out = append(out, ':')
+		}
+		i += keyLength + 1
+
+		var n int
+		out, n, err = e.encodeValue(out, bsonType, elements[i:])
+		if err != nil {
+			return nil, 0, err
+		}
+		i += n
+	}
+
+	out = append(out, closeCh)
+
+	return out, length, nil
+}
+
+// encodeValue appends the JSON form of a BSON value of a given type.  The input
+// starts at the value bytes and may extend past them.  It returns the number of
+// input bytes consumed.
+func (e *Encoder) encodeValue(out []byte, bsonType byte, in []byte) ([]byte, int, error) {
+	switch bsonType {
+	case bsonDouble:
+		if len(in) < 8 {
+			return nil, 0, newBSONError("double truncated")
+		}
+		f := math.Float64frombits(binary.LittleEndian.Uint64(in))
+		if math.IsNaN(f) || math.IsInf(f, 0) || e.extJSONMode == CanonicalExtJSON {
+			if e.extJSONMode == PlainJSON {
+				return nil, 0, fmt.Errorf("double value %v can't be represented in JSON", f)
+			}
+			out = encodeNumberDouble(out, f)
+			return out, 8, nil
+		}
+		out = appendDouble(out, f)
+		return out, 8, nil
+	case bsonString:
+		str, n, err := readString(in)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = appendJSONString(out, str)
+		return out, n, nil
+	case bsonDocument:
+		return e.encodeDocument(out, in, false)
+	case bsonArray:
+		return e.encodeDocument(out, in, true)
+	case bsonBoolean:
+		if len(in) < 1 {
+			return nil, 0, newBSONError("boolean truncated")
+		}
+		switch in[0] {
+		case 0:
+			out = append(out, "false"...)
+		case 1:
+			out = append(out, "true"...)
+		default:
+			return nil, 0, newBSONError("invalid boolean value %d", in[0])
+		}
+		return out, 1, nil
+	case bsonNull:
+		out = append(out, "null"...)
+		return out, 0, nil
+	case bsonInt32:
+		if len(in) < 4 {
+			return nil, 0, newBSONError("int32 truncated")
+		}
+		n := int64(int32(binary.LittleEndian.Uint32(in)))
+		if e.extJSONMode == CanonicalExtJSON {
+			out = encodeNumberInt(out, jsonNumberInt, n)
+			return out, 4, nil
+		}
+		out = strconv.AppendInt(out, n, 10)
+		return out, 4, nil
+	case bsonInt64:
+		if len(in) < 8 {
+			return nil, 0, newBSONError("int64 truncated")
+		}
+		n := int64(binary.LittleEndian.Uint64(in))
+		if e.extJSONMode == CanonicalExtJSON {
+			out = encodeNumberInt(out, jsonNumberLong, n)
+			return out, 8, nil
+		}
+		out = strconv.AppendInt(out, n, 10)
+		return out, 8, nil
+	default:
+		if e.extJSONMode == PlainJSON {
+			return nil, 0, fmt.Errorf("BSON type 0x%02x can't be represented in JSON", bsonType)
+		}
+		return e.encodeExtJSON(out, bsonType, in)
+	}
+}
+
+// readDocumentLength reads and validates the length prefix of a BSON document
+// or array.  The length must fit in the input and the document must end with a
+// null byte.
+func readDocumentLength(in []byte) (int, error) {
+	if len(in) < 4 {
+		return 0, newBSONError("document length truncated")
+	}
+	length := int(int32(binary.LittleEndian.Uint32(in)))
+	if length < 5 {
+		return 0, newBSONError("document length %d too short", length)
+	}
+	if length > len(in) {
+		return 0, newBSONError("document length %d exceeds available bytes %d", length, len(in))
+	}
+	if in[length-1] != nullByte {
+		return 0, newBSONError("document not null terminated")
+	}
+	return length, nil
+}
+
+// readString reads a length-prefixed BSON string.  It returns the string
+// bytes, without the null terminator, and the total number of bytes consumed.
+func readString(in []byte) ([]byte, int, error) {
+	if len(in) < 4 {
+		return nil, 0, newBSONError("string length truncated")
+	}
+	length := int(int32(binary.LittleEndian.Uint32(in)))
+	if length < 1 {
+		return nil, 0, newBSONError("string length %d too short", length)
+	}
+	if length > len(in)-4 {
+		return nil, 0, newBSONError("string length %d exceeds available bytes %d", length, len(in)-4)
+	}
+	if in[4+length-1] != nullByte {
+		return nil, 0, newBSONError("string not null terminated")
+	}
+	str := in[4 : 4+length-1]
+	if !utf8.Valid(str) {
+		return nil, 0, newBSONError("string not valid UTF-8")
+	}
+	return str, 4 + length, nil
+}
+
+// appendDouble prints exactly one decimal place for integral values, so that a
+// Decoder reads them back as doubles; otherwise, it prints as many digits as
+// necessary to represent the value exactly.
+func appendDouble(out []byte, f float64) []byte {
+	start := len(out)
+	out = strconv.AppendFloat(out, f, 'G', -1, 64)
+	if bytes.IndexAny(out[start:], ".E") < 0 {
+		out = append(out, '.', '0')
+	}
+	return out
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends the input as a quoted JSON string, escaping
+// quotes, backslashes, and control characters.  Input is expected to be
+// well-formed UTF-8 and is otherwise copied unmodified.
+func appendJSONString(out []byte, in []byte) []byte {
+	out = append(out, '"')
+	start := 0
+	for i, c := range in {
+		if c >= ' ' && c != '"' && c != '\\' {
+			continue
+		}
+		out = append(out, in[start:i]...)
+		switch c {
+		case '"', '\\':
+			out = append(out, '\\', c)
+		case '\b':
+			out = append(out, '\\', 'b')
+		case '\f':
+			out = append(out, '\\', 'f')
+		case '\n':
+			out = append(out, '\\', 'n')
+		case '\r':
+			out = append(out, '\\', 'r')
+		case '\t':
+			out = append(out, '\\', 't')
+		default:
+			out = append(out, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+		}
+		start = i + 1
+	}
+	out = append(out, in[start:]...)
+	out = append(out, '"')
+	return out
+}
+
+// Marshal converts a single BSON document to a JSON object.  The function
+// takes an output buffer as an argument.  If the buffer is not large enough, a
+// new buffer will be allocated on demand.  The final buffer is returned, just
+// like with `append`.  BSON types without a JSON equivalent are an error.
+func Marshal(in []byte, out []byte) ([]byte, error) {
+	enc := &Encoder{maxDepth: 200}
+	return enc.encodeTopDocument(out, in)
+}
+
+// MarshalExtJSON converts a single BSON document to an Extended JSON object,
+// in canonical or relaxed form like `Encoder.ExtJSON`.  With PlainJSON, it is
+// the same as `Marshal`.  It otherwise works like `Marshal`.
+func MarshalExtJSON(in []byte, out []byte, mode ExtJSONMode) ([]byte, error) {
+	enc := &Encoder{maxDepth: 200, extJSONMode: mode}
+	return enc.encodeTopDocument(out, in)
+}
+
+// newBSONError is used when BSON input to an Encoder is malformed or can't be
+// represented in the output.
+func newBSONError(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid BSON: %s", fmt.Sprintf(format, args...))
+}
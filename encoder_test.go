@@ -0,0 +1,342 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type marshalTestCase struct {
+	label  string
+	input  string
+	output string
+	errStr string
+}
+
+func testWithMarshal(t *testing.T, cases []marshalTestCase, mode ExtJSONMode) {
+	t.Helper()
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+
+			input, err := hex.DecodeString(c.input)
+			if err != nil {
+				t.Fatalf("error decoding test input: %v", err)
+			}
+			buf := make([]byte, 0, 256)
+			switch mode {
+			case PlainJSON:
+				buf, err = Marshal(input, buf)
+			default:
+				buf, err = MarshalExtJSON(input, buf, mode)
+			}
+			if c.errStr != "" {
+				var got string
+				if err != nil {
+					got = err.Error()
+					t.Log(got)
+				}
+				if !strings.Contains(got, c.errStr) {
+					t.Errorf("expected error with '%s', but got %v", c.errStr, got)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				} else if string(buf) != c.output {
+					t.Fatalf("Marshal doesn't match expected:\nGot:    %s\nExpect: %s", string(buf), c.output)
+				}
+			}
+		})
+	}
+}
+
+// TestMarshal tests the Marshal function with JSON-compatible BSON types and
+// malformed BSON.
+func TestMarshal(t *testing.T) {
+	t.Parallel()
+
+	cases := []marshalTestCase{
+		{
+			label:  "empty doc",
+			input:  "0500000000",
+			output: `{}`,
+		},
+		{
+			label:  "empty subdoc",
+			input:  "0c0000000300050000000000",
+			output: `{"":{}}`,
+		},
+		{
+			label:  "true",
+			input:  "090000000862000100",
+			output: `{"b":true}`,
+		},
+		{
+			label:  "false",
+			input:  "090000000862000000",
+			output: `{"b":false}`,
+		},
+		{
+			label:  "null",
+			input:  "080000000A610000",
+			output: `{"a":null}`,
+		},
+		{
+			label:  "string",
+			input:  "190000000261000D0000006162616261626162616261620000",
+			output: `{"a":"abababababab"}`,
+		},
+		{
+			label:  "string with UTF-8",
+			input:  "190000000261000D000000C3A9C3A9C3A9C3A9C3A9C3A90000",
+			output: `{"a":"éééééé"}`,
+		},
+		{
+			label:  "string with required escapes",
+			input:  "320000000261002600000061625C220102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F61620000",
+			output: `{"a":"ab\\\"\u0001\u0002\u0003\u0004\u0005\u0006\u0007\b\t\n\u000b\f\r\u000e\u000f\u0010\u0011\u0012\u0013\u0014\u0015\u0016\u0017\u0018\u0019\u001a\u001b\u001c\u001d\u001e\u001fab"}`,
+		},
+		{
+			label:  "key with escapes",
+			input:  "0b0000000a610a62220000",
+			output: `{"a\nb\"":null}`,
+		},
+		{
+			label:  "int32",
+			input:  "0C0000001069000000008000",
+			output: `{"i":-2147483648}`,
+		},
+		{
+			label:  "int64",
+			input:  "10000000126100000000000000008000",
+			output: `{"a":-9223372036854775808}`,
+		},
+		{
+			label:  "double integral",
+			input:  "10000000016400000000000000F03F00",
+			output: `{"d":1.0}`,
+		},
+		{
+			label:  "double fractional",
+			input:  "10000000016400000000008000F03F00",
+			output: `{"d":1.0001220703125}`,
+		},
+		{
+			label:  "double exponent",
+			input:  "1000000001640081E97DF41022B14300",
+			output: `{"d":1.2345678901234568E+18}`,
+		},
+		{
+			label:  "double negative zero",
+			input:  "10000000016400000000000000008000",
+			output: `{"d":-0.0}`,
+		},
+		{
+			label:  "array",
+			input:  "1b000000046100130000001030000a000000103100140000000000",
+			output: `{"a":[10,20]}`,
+		},
+		{
+			label:  "nested",
+			input:  "1c000000036100140000000462000c0000000a300008310001000000",
+			output: `{"a":{"b":[null,true]}}`,
+		},
+
+		// Errors
+		{
+			label:  "double NaN",
+			input:  "10000000016400000000000000F87F00",
+			errStr: "can't be represented in JSON",
+		},
+		{
+			label:  "ObjectID",
+			input:  "1400000007610056E1FC72E0C917E9C471416100",
+			errStr: "can't be represented in JSON",
+		},
+		{
+			label:  "length too long",
+			input:  "0600000000",
+			errStr: "exceeds available bytes",
+		},
+		{
+			label:  "length too short",
+			input:  "090000000862000100ff",
+			errStr: "doesn't match input length",
+		},
+		{
+			label:  "not null terminated",
+			input:  "05000000ff",
+			errStr: "not null terminated",
+		},
+		{
+			label:  "string not null terminated",
+			input:  "0E00000002610002000000626300",
+			errStr: "string not null terminated",
+		},
+		{
+			label:  "bad boolean",
+			input:  "090000000862000200",
+			errStr: "invalid boolean",
+		},
+		{
+			label:  "int32 truncated",
+			input:  "0b00000010690000000000",
+			errStr: "int32 truncated",
+		},
+		{
+			label:  "unterminated key",
+			input:  "080000000A616100",
+			errStr: "unterminated element key",
+		},
+	}
+
+	testWithMarshal(t, cases, PlainJSON)
+}
+
+// TestMarshalExtJSON tests a single conversion of types that differ between
+// relaxed and canonical Extended JSON.  The MongoDB BSON corpus tests cover
+// the rest.
+func TestMarshalExtJSON(t *testing.T) {
+	t.Parallel()
+
+	mixed := "4A000000106900010000001269000200000000000000016400000000000000F83F09640000DC1FD2770000000964000000000000000080076F0056E1FC72E0C917E9C47141610A6E0000"
+
+	t.Run("plain", func(t *testing.T) {
+		cases := []marshalTestCase{
+			{
+				label:  "ObjectID",
+				input:  "1400000007610056E1FC72E0C917E9C471416100",
+				errStr: "BSON type 0x07 can't be represented in JSON",
+			},
+		}
+		testWithMarshal(t, cases, PlainJSON)
+	})
+
+	t.Run("relaxed", func(t *testing.T) {
+		cases := []marshalTestCase{
+			{
+				label:  "mixed",
+				input:  mixed,
+				output: `{"i":1,"i":2,"d":1.5,"d":{"$date":"1986-04-23T07:46:51.52Z"},"d":{"$date":{"$numberLong":"-9223372036854775808"}},"o":{"$oid":"56e1fc72e0c917e9c4714161"},"n":null}`,
+			},
+			{
+				label:  "NaN",
+				input:  "10000000016400000000000000F87F00",
+				output: `{"d":{"$numberDouble":"NaN"}}`,
+			},
+		}
+		testWithMarshal(t, cases, RelaxedExtJSON)
+	})
+
+	t.Run("canonical", func(t *testing.T) {
+		cases := []marshalTestCase{
+			{
+				label:  "mixed",
+				input:  mixed,
+				output: `{"i":{"$numberInt":"1"},"i":{"$numberLong":"2"},"d":{"$numberDouble":"1.5"},"d":{"$date":{"$numberLong":"514626411520"}},"d":{"$date":{"$numberLong":"-9223372036854775808"}},"o":{"$oid":"56e1fc72e0c917e9c4714161"},"n":null}`,
+			},
+		}
+		testWithMarshal(t, cases, CanonicalExtJSON)
+	})
+}
+
+// TestEncoder checks that the Encoder writes newline-delimited objects.
+func TestEncoder(t *testing.T) {
+	t.Parallel()
+
+	var w bytes.Buffer
+	enc := NewEncoder(&w)
+	for _, input := range []string{`{"a":1}`, `{"b":[true,"c"]}`} {
+		doc, err := Unmarshal([]byte(input), nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = enc.Encode(doc)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expect := "{\"a\":1}\n{\"b\":[true,\"c\"]}\n"
+	if w.String() != expect {
+		t.Fatalf("Encoder output doesn't match expected:\nGot:    %q\nExpect: %q", w.String(), expect)
+	}
+
+	err := enc.Encode([]byte{0x05, 0x00})
+	if err == nil {
+		t.Fatal("expected error but got nil")
+	}
+}
+
+// TestEncoderDepthLimit checks the ability to set a depth limit.
+func TestEncoderDepthLimit(t *testing.T) {
+	t.Parallel()
+
+	doc, err := Unmarshal([]byte(`{"1":{"2":{"3":[{"5":"a"}]}}}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := NewEncoder(ioutil.Discard)
+	enc.MaxDepth(4)
+	err = enc.Encode(doc)
+	if err == nil {
+		t.Fatalf("expected error and got nil")
+	}
+
+	enc.MaxDepth(5)
+	err = enc.Encode(doc)
+	if err != nil {
+		t.Fatalf("expected no error and got: %v", err)
+	}
+}
+
+// TestRoundTrip_JSONTestSuite converts the seriot.ch "y" corpus from JSON to
+// BSON, back to JSON and back to BSON again, and checks that both BSON
+// conversions match.
+func TestRoundTrip_JSONTestSuite(t *testing.T) {
+	t.Parallel()
+
+	files := getTestFiles(t, JSONTestSuite, "y", ".json")
+	for _, f := range files {
+		path := filepath.Join(JSONTestSuite, f)
+		t.Run(f, func(t *testing.T) {
+			t.Parallel()
+			text, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("error reading %s: %v", path, err)
+			}
+			first, err := convertWithJibby(objectify(text))
+			if err != nil {
+				for _, v := range allowedErrors {
+					if strings.Contains(err.Error(), v) {
+						return
+					}
+				}
+				t.Fatalf("jibby error: %v", err)
+			}
+			json, err := Marshal(first, nil)
+			if err != nil {
+				t.Fatalf("marshal error: %v", err)
+			}
+			second, err := convertWithJibby(json)
+			if err != nil {
+				t.Fatalf("jibby error: %v\ntext: %s", err, string(json))
+			}
+			if !bytes.Equal(first, second) {
+				t.Fatalf("round trip doesn't match:\nFirst:  %v\nSecond: %v\nJSON: %s", hex.EncodeToString(first), hex.EncodeToString(second), string(json))
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// encoding identifies the Unicode encoding of the input.
+type encoding int
+
+const (
+	encodingUTF8 encoding = iota
+	encodingUTF16LE
+	encodingUTF16BE
+	encodingUTF32LE
+	encodingUTF32BE
+)
+
+// detectEncoding peeks at the start of the input to find its encoding from a
+// byte order mark.  Without one, it uses the heuristic from RFC 4627, section
+// 3: the first two characters of JSON text are ASCII, so the pattern of null
+// bytes in the first four bytes indicates the encoding.  Any BOM is left in
+// the input.
+func detectEncoding(r *bufio.Reader) encoding {
+	// Input shorter than four bytes is fine; check whatever is there.
+	b, _ := r.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(b, utf32BEBOM):
+		return encodingUTF32BE
+	case bytes.HasPrefix(b, utf32LEBOM):
+		return encodingUTF32LE
+	case bytes.HasPrefix(b, utf16BEBOM):
+		return encodingUTF16BE
+	case bytes.HasPrefix(b, utf16LEBOM):
+		return encodingUTF16LE
+	}
+
+	if len(b) == 4 {
+		switch {
+		case b[0] == 0 && b[1] == 0 && b[2] == 0 && b[3] != 0:
+			return encodingUTF32BE
+		case b[0] != 0 && b[1] == 0 && b[2] == 0 && b[3] == 0:
+			return encodingUTF32LE
+		}
+	}
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0 && b[1] != 0:
+			return encodingUTF16BE
+		case b[0] != 0 && b[1] == 0:
+			return encodingUTF16LE
+		}
+	}
+
+	return encodingUTF8
+}
+
+// transcoder reads UTF-16 or UTF-32 input and converts it to UTF-8 as it
+// streams.  Invalid code units, like unpaired surrogates, and any incomplete
+// code unit at the end of the input are converted to the Unicode replacement
+// character, U+FFFD.  A BOM is converted like any other character.
+type transcoder struct {
+	r     io.Reader
+	order binary.ByteOrder
+	width int
+	in    []byte
+	start int
+	end   int
+	out   []byte
+	next  []byte
+	err   error
+}
+
+func newTranscoder(r io.Reader, enc encoding) *transcoder {
+	t := &transcoder{r: r, in: make([]byte, 4096)}
+	switch enc {
+	case encodingUTF16LE:
+		t.order, t.width = binary.LittleEndian, 2
+	case encodingUTF16BE:
+		t.order, t.width = binary.BigEndian, 2
+	case encodingUTF32LE:
+		t.order, t.width = binary.LittleEndian, 4
+	case encodingUTF32BE:
+		t.order, t.width = binary.BigEndian, 4
+	}
+	return t
+}
+
+func (t *transcoder) Read(b []byte) (int, error) {
+	for len(t.next) == 0 {
+		if t.err != nil {
+			return 0, t.err
+		}
+		t.fill()
+	}
+	n := copy(b, t.next)
+	t.next = t.next[n:]
+	return n, nil
+}
+
+// fill reads more input and converts as much of it as possible.
+func (t *transcoder) fill() {
+	// Keep any incomplete code units from the last read.
+	t.end = copy(t.in, t.in[t.start:t.end])
+	t.start = 0
+
+	var n int
+	n, t.err = t.r.Read(t.in[t.end:])
+	t.end += n
+
+	var x [utf8.UTFMax]byte
+	xs := x[0:utf8.UTFMax]
+	t.out = t.out[0:0]
+	for t.end-t.start >= t.width {
+		r, size := t.decodeRune()
+		if size == 0 {
+			// A high surrogate needs another code unit.
+			break
+		}
+		t.start += size
+		t.out = append(t.out, xs[0:utf8.EncodeRune(xs, r)]...)
+	}
+
+	// At the end of input, anything left is incomplete.
+	if t.err != nil && t.start < t.end {
+		t.start = t.end
+		t.out = append(t.out, replacementChar...)
+	}
+	t.next = t.out
+}
+
+// decodeRune converts the code units at the start of the input.  It returns
+// the rune and the number of bytes used, or zero bytes if the rune needs more
+// input.
+func (t *transcoder) decodeRune() (rune, int) {
+	in := t.in[t.start:t.end]
+
+	if t.width == 4 {
+		r := rune(t.order.Uint32(in))
+		if r > unicode.MaxRune || utf16.IsSurrogate(r) {
+			r = unicode.ReplacementChar
+		}
+		return r, 4
+	}
+
+	r1 := rune(t.order.Uint16(in))
+	if !utf16.IsSurrogate(r1) {
+		return r1, 2
+	}
+	if len(in) < 4 {
+		if t.err == nil {
+			return 0, 0
+		}
+		return unicode.ReplacementChar, 2
+	}
+	// If the second code unit doesn't complete a pair, only the first is
+	// replaced and the second is decoded next.
+	r := utf16.DecodeRune(r1, rune(t.order.Uint16(in[2:])))
+	if r == unicode.ReplacementChar {
+		return r, 2
+	}
+	return r, 4
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// encodeUTF16 converts UTF-8 text to UTF-16 code units, with an optional BOM.
+func encodeUTF16(s string, order binary.ByteOrder, bom bool) []byte {
+	if bom {
+		s = "\uFEFF" + s
+	}
+	units := utf16.Encode([]rune(s))
+	out := make([]byte, 2*len(units))
+	for i, u := range units {
+		order.PutUint16(out[2*i:], u)
+	}
+	return out
+}
+
+// encodeUTF32 converts UTF-8 text to UTF-32 code units, with an optional BOM.
+func encodeUTF32(s string, order binary.ByteOrder, bom bool) []byte {
+	if bom {
+		s = "\uFEFF" + s
+	}
+	runes := []rune(s)
+	out := make([]byte, 4*len(runes))
+	for i, r := range runes {
+		order.PutUint32(out[4*i:], uint32(r))
+	}
+	return out
+}
+
+func decodeAll(t *testing.T, input []byte) []byte {
+	t.Helper()
+	jib, err := NewDecoder(bufio.NewReader(bytes.NewReader(input)))
+	if err != nil {
+		t.Fatalf("error creating decoder: %v", err)
+	}
+	var out []byte
+	for {
+		out, err = jib.Decode(out)
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("error decoding: %v", err)
+		}
+	}
+}
+
+// TestTranscoding checks that UTF-16 and UTF-32 input, with and without a BOM,
+// converts the same as UTF-8 input.
+func TestTranscoding(t *testing.T) {
+	t.Parallel()
+
+	texts := map[string]string{
+		"ascii":     `{"a":true} {"b":[1,2.5,null]}`,
+		"array":     `[{"a":1},{"b":2}]`,
+		"non-ASCII": `{"é":"日本語","clef":"𝄞"}`,
+		"long":      `{"a":"` + strings.Repeat("𝄞x", 3000) + `"}`,
+	}
+
+	type encoder struct {
+		label  string
+		encode func(s string, order binary.ByteOrder, bom bool) []byte
+		order  binary.ByteOrder
+	}
+	encoders := []encoder{
+		{"UTF-16LE", encodeUTF16, binary.LittleEndian},
+		{"UTF-16BE", encodeUTF16, binary.BigEndian},
+		{"UTF-32LE", encodeUTF32, binary.LittleEndian},
+		{"UTF-32BE", encodeUTF32, binary.BigEndian},
+	}
+
+	for label, text := range texts {
+		label, text := label, text
+		expect := decodeAll(t, []byte(text))
+		for _, enc := range encoders {
+			for _, bom := range []bool{true, false} {
+				enc, bom := enc, bom
+				name := enc.label + " " + label
+				if bom {
+					name += " with BOM"
+				}
+				t.Run(name, func(t *testing.T) {
+					t.Parallel()
+					got := decodeAll(t, enc.encode(text, enc.order, bom))
+					if !bytes.Equal(got, expect) {
+						t.Errorf("expected %s, but got %s", hex.EncodeToString(expect), hex.EncodeToString(got))
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestTranscodingInvalid checks that invalid code units are replaced.
+func TestTranscodingInvalid(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		label       string
+		input       []byte
+		output      string
+		trailingErr bool
+	}{
+		{
+			label:  "lone high surrogate",
+			input:  []byte("{\x00\"\x00a\x00\"\x00:\x00\"\x00\x00\xd8\"\x00}\x00"),
+			output: `{"a":"` + replacementChar + `"}`,
+		},
+		{
+			label:  "lone low surrogate",
+			input:  []byte("{\x00\"\x00a\x00\"\x00:\x00\"\x00\x00\xdcb\x00\"\x00}\x00"),
+			output: `{"a":"` + replacementChar + `b"}`,
+		},
+		{
+			label:  "inverted surrogates",
+			input:  []byte("{\x00\"\x00a\x00\"\x00:\x00\"\x00\x1e\xdd\x34\xd8\"\x00}\x00"),
+			output: `{"a":"` + replacementChar + replacementChar + `"}`,
+		},
+		{
+			label:  "UTF-32 out of range",
+			input:  []byte("{\x00\x00\x00\"\x00\x00\x00a\x00\x00\x00\"\x00\x00\x00:\x00\x00\x00\"\x00\x00\x00\x00\x00\x11\x00\"\x00\x00\x00}\x00\x00\x00"),
+			output: `{"a":"` + replacementChar + `"}`,
+		},
+		{
+			label:       "trailing odd byte",
+			input:       []byte("{\x00}\x00 "),
+			output:      `{}`,
+			trailingErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+			jib, err := NewDecoder(bufio.NewReader(bytes.NewReader(c.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := jib.Decode(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			json, err := Marshal(got, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(json) != c.output {
+				t.Errorf("expected %s, but got %s", c.output, json)
+			}
+
+			// An incomplete code unit at the end is replaced, which is
+			// invalid JSON after the document.
+			_, err = jib.Decode(nil)
+			if c.trailingErr && (err == nil || err == io.EOF) {
+				t.Errorf("expected error after document, but got %v", err)
+			}
+			if !c.trailingErr && err != io.EOF {
+				t.Errorf("expected io.EOF after document, but got %v", err)
+			}
+		})
+	}
+}
+
+// TestTranscoding_TestSuites checks UTF-16 files from the test corpora.
+func TestTranscoding_TestSuites(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		path   string
+		output string
+	}{
+		{filepath.Join(JibbyTestSuite, "utf16le_nobom.json"), `{"a":true}`},
+		{filepath.Join(JibbyTestSuite, "utf16be_nobom.json"), `{"a":true}`},
+		{filepath.Join(JSONTestSuite, "i_string_UTF-16LE_with_BOM.json"), `["é"]`},
+		{filepath.Join(JSONTestSuite, "i_string_utf16BE_no_BOM.json"), `["é"]`},
+		{filepath.Join(JSONTestSuite, "i_string_utf16LE_no_BOM.json"), `["é"]`},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(filepath.Base(c.path), func(t *testing.T) {
+			t.Parallel()
+			text, err := ioutil.ReadFile(c.path)
+			if err != nil {
+				t.Fatalf("error reading %s: %v", c.path, err)
+			}
+			_, expect, err := UnmarshalValue([]byte(c.output), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, got, err := UnmarshalValue(text, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, expect) {
+				t.Errorf("expected %s, but got %s", hex.EncodeToString(expect), hex.EncodeToString(got))
+			}
+		})
+	}
+}
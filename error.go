@@ -1,9 +1,60 @@
 package jibby
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
 // ParseError records JSON/Extended JSON parsing errors.  It can include a small
 // excerpt of text from the reader at the point of error.
 type ParseError struct {
+	// Offset is the byte offset in the input stream where the error occurs,
+	// counting from the start of the stream, including any byte order mark.
+	Offset int64
+	// Line and Column are the 1-based line and byte column of Offset.
+	Line   int
+	Column int
+	// Document is the 0-based index of the top-level value in the stream
+	// that contains the error.
+	Document int
+	// Path is a JSON Pointer (RFC 6901) to the element that contains the
+	// error, e.g. `/orders/3/price`.  It is empty if the error is not inside
+	// any element of the top-level value.
+	Path string
+	// Skipped is the text of the malformed top-level value that was skipped
+	// when the Decoder has error recovery enabled.
+	Skipped []byte
+
 	msg string
 }
 
 func (pe *ParseError) Error() string { return pe.msg }
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// prependPath adds a reference token to the front of the Path of a ParseError.
+// As an error returns up through nested objects and arrays, each adds the key
+// or index of the element that failed.  Other errors are returned unchanged.
+func prependPath(err error, token string) error {
+	var pe *ParseError
+	if errors.As(err, &pe) {
+		pe.Path = "/" + jsonPointerEscaper.Replace(token) + pe.Path
+	}
+	return err
+}
+
+// DocumentSizeError means that the BSON document converted from a top-level
+// value is larger than the maximum size set with MaxDocumentSize.
+type DocumentSizeError struct {
+	// Document is the 0-based index of the top-level value in the stream.
+	Document int
+	// Offset is the byte offset in the input stream where the value starts.
+	Offset int64
+	// Limit is the maximum document size in bytes.
+	Limit int
+}
+
+func (se *DocumentSizeError) Error() string {
+	return fmt.Sprintf("document %d at offset %d exceeds maximum size of %d bytes", se.Document, se.Offset, se.Limit)
+}
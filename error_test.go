@@ -1,8 +1,11 @@
 package jibby
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
 )
 
@@ -22,3 +25,193 @@ func TestParseError(t *testing.T) {
 		t.Fatal("wrapped error wasn't a ParseError")
 	}
 }
+
+func TestParseErrorPosition(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label  string
+		input  string
+		offset int64
+		line   int
+		column int
+		doc    int
+		path   string
+	}
+
+	cases := []testCase{
+		{
+			label:  "first character",
+			input:  `{,}`,
+			offset: 1,
+			line:   1,
+			column: 2,
+			doc:    0,
+			path:   "",
+		},
+		{
+			label:  "nested in array",
+			input:  `{"orders":[{"price":1},{"price":2},{"price":3},{"price":x}]}`,
+			offset: 56,
+			line:   1,
+			column: 57,
+			doc:    0,
+			path:   "/orders/3/price",
+		},
+		{
+			label:  "later document, later line",
+			input:  "{\"a\":1}\n{\"a\":2}\n{\"b\":\n  {\"c\": tru}}",
+			offset: 30,
+			line:   4,
+			column: 9,
+			doc:    2,
+			path:   "/b/c",
+		},
+		{
+			label:  "top-level array",
+			input:  "[{\"a\":1},\r\n {\"a~/b\":[true, nul]}]",
+			offset: 27,
+			line:   2,
+			column: 17,
+			doc:    1,
+			path:   "/a~0~1b/1",
+		},
+		{
+			label:  "extended JSON",
+			input:  `{"a":{"$date":"the twelfth of never"}}`,
+			offset: 15,
+			line:   1,
+			column: 16,
+			doc:    0,
+			path:   "/a",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(c.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jib.ExtJSON(true)
+			for err == nil {
+				_, err = jib.Decode(nil)
+			}
+
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("error wasn't a ParseError: %v", err)
+			}
+			if pe.Offset != c.offset || pe.Line != c.line || pe.Column != c.column {
+				t.Errorf("expected offset %d, line %d, column %d, but got %d, %d, %d", c.offset, c.line, c.column, pe.Offset, pe.Line, pe.Column)
+			}
+			if pe.Document != c.doc {
+				t.Errorf("expected document %d, but got %d", c.doc, pe.Document)
+			}
+			if pe.Path != c.path {
+				t.Errorf("expected path %q, but got %q", c.path, pe.Path)
+			}
+		})
+	}
+}
+
+// TestParseErrorLineCount checks line counting when errors occur far into a
+// stream with many newlines.
+func TestParseErrorLineCount(t *testing.T) {
+	t.Parallel()
+
+	input := strings.Repeat("{\"a\":1}\n", 10000) + `{"a":x}`
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, err = jib.Decode(nil)
+	}
+
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("error wasn't a ParseError: %v", err)
+	}
+	if pe.Offset != 80005 || pe.Line != 10001 || pe.Column != 6 || pe.Document != 10000 {
+		t.Errorf("expected offset 80005, line 10001, column 6, document 10000, but got %d, %d, %d, %d", pe.Offset, pe.Line, pe.Column, pe.Document)
+	}
+}
+
+// TestParseErrorBufferSize checks positions with input that is read from the
+// caller's buffer directly, as well as rebuffered, including after error
+// recovery starts capturing input partway through the stream.
+func TestParseErrorBufferSize(t *testing.T) {
+	t.Parallel()
+
+	input := strings.Repeat("{\"a\":1}\n", 10000) + "{\"a\":\n1}\n" + `{"a":x}`
+	for _, size := range []int{16, minBufferSize, 1 << 20} {
+		for _, framing := range []Framing{AutoFraming, NDJSONFraming} {
+			size, framing := size, framing
+			t.Run(fmt.Sprintf("size %d framing %d", size, framing), func(t *testing.T) {
+				t.Parallel()
+				jib, err := NewDecoder(bufio.NewReaderSize(strings.NewReader(input), size))
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = jib.Framing(framing)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var errs []*ParseError
+				for i := 0; err != io.EOF; i++ {
+					if i == 5000 {
+						jib.Recover(true)
+					}
+					_, err = jib.Decode(nil)
+					var pe *ParseError
+					if errors.As(err, &pe) {
+						errs = append(errs, pe)
+					} else if err != nil && err != io.EOF {
+						t.Fatal(err)
+					}
+				}
+
+				expect := [][3]int{{80014, 10003, 6}}
+				if framing == NDJSONFraming {
+					expect = [][3]int{{80005, 10001, 6}, {80006, 10002, 1}, {80014, 10003, 6}}
+				}
+				if len(errs) != len(expect) {
+					t.Fatalf("expected %d errors, but got %d", len(expect), len(errs))
+				}
+				for i, pe := range errs {
+					got := [3]int{int(pe.Offset), pe.Line, pe.Column}
+					if got != expect[i] {
+						t.Errorf("error %d: expected offset, line and column %v, but got %v", i, expect[i], got)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestParseErrorLongLine checks the column of an error on a line that started
+// before the window of input kept for error reporting.
+func TestParseErrorLongLine(t *testing.T) {
+	t.Parallel()
+
+	input := strings.Repeat("{\"a\":1}\n", 10000) + `{"a":"` + strings.Repeat("x", 100000) + `","b":x}`
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, err = jib.Decode(nil)
+	}
+
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("error wasn't a ParseError: %v", err)
+	}
+	if pe.Offset != 180012 || pe.Line != 10001 || pe.Column != 100013 {
+		t.Errorf("expected offset 180012, line 10001, column 100013, but got %d, %d, %d", pe.Offset, pe.Line, pe.Column)
+	}
+}
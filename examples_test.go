@@ -9,6 +9,7 @@ package jibby_test
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"log"
 
 	"github.com/xdg-go/jibby"
@@ -45,3 +46,44 @@ func ExampleDecoder_Decode() {
 	// Do something with bson
 	_ = bson
 }
+
+func ExampleMarshal() {
+	bson, err := jibby.Unmarshal([]byte(`{"a": 1, "b": "foo"}`), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	json := make([]byte, 0, 256)
+	json, err = jibby.Marshal(bson, json)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(string(json))
+	// Output: {"a":1,"b":"foo"}
+}
+
+func ExampleTokenizer_Walk() {
+	jsonReader := bufio.NewReader(bytes.NewReader([]byte(`{"a": [1, "foo"]}`)))
+	tok := jibby.NewTokenizer(jsonReader)
+
+	err := tok.Walk(func(t jibby.Token) error {
+		if t.Value != nil {
+			fmt.Printf("%d %s %s\n", t.Offset, t.Kind, t.Value)
+			return nil
+		}
+		fmt.Printf("%d %s\n", t.Offset, t.Kind)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// 0 start object
+	// 1 key a
+	// 6 start array
+	// 7 number 1
+	// 10 string foo
+	// 15 end array
+	// 16 end object
+}
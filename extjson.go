@@ -230,6 +230,23 @@ func (d *Decoder) convertOID(out []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	out, err = d.convertOIDString(out)
+	if err != nil {
+		return nil, err
+	}
+
+	// Must end with document terminator
+	err = d.readObjectTerminator()
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// convertOIDString starts after the opening quote of a string holding the hex
+// bytes of an ObjectID and consumes the string and closing quote.
+func (d *Decoder) convertOIDString(out []byte) ([]byte, error) {
 	// peek ahead for 24 bytes and closing quote
 	buf, err := d.json.Peek(25)
 	if err != nil {
@@ -250,12 +267,6 @@ func (d *Decoder) convertOID(out []byte) ([]byte, error) {
 
 	_, _ = d.json.Discard(25)
 
-	// Must end with document terminator
-	err = d.readObjectTerminator()
-	if err != nil {
-		return nil, err
-	}
-
 	return out, nil
 }
 
@@ -368,23 +379,10 @@ func (d *Decoder) convertDate(out []byte) ([]byte, error) {
 	}
 	switch ch {
 	case '"':
-		// Shortest ISO-8601 is `YYYY-MM-DDTHH:MM:SSZ` (20 chars); longest is
-		// `YYYY-MM-DDTHH:MM:SS.sss+HH:MM` (29 chars).  Plus we need the closing
-		// quote.  Peek a little further in case extra precision is given
-		// (counter to the spec).
-		buf, err := d.peekBoundedQuote(21, 48, "ISO 8601 datetime")
+		out, err = d.convertDateString(out)
 		if err != nil {
 			return nil, err
 		}
-		epochMillis, err := parseISO8601toEpochMillis(buf)
-		if err != nil {
-			return nil, d.parseError(nil, err.Error())
-		}
-		_, _ = d.json.Discard(len(buf) + 1)
-		var x [8]byte
-		xs := x[0:8]
-		binary.LittleEndian.PutUint64(xs, uint64(epochMillis))
-		out = append(out, xs...)
 	case '{':
 		err = d.readQuoteStart()
 		if err != nil {
@@ -424,6 +422,30 @@ func (d *Decoder) convertDate(out []byte) ([]byte, error) {
 	return out, nil
 }
 
+// convertDateString starts after the opening quote of an ISO-8601 datetime
+// string and consumes the string and closing quote.
+func (d *Decoder) convertDateString(out []byte) ([]byte, error) {
+	// Shortest ISO-8601 is `YYYY-MM-DDTHH:MM:SSZ` (20 chars); longest is
+	// `YYYY-MM-DDTHH:MM:SS.sss+HH:MM` (29 chars).  Plus we need the closing
+	// quote.  Peek a little further in case extra precision is given
+	// (counter to the spec).
+	buf, err := d.peekBoundedQuote(21, 48, "ISO 8601 datetime")
+	if err != nil {
+		return nil, err
+	}
+	epochMillis, err := parseISO8601toEpochMillis(buf)
+	if err != nil {
+		return nil, d.parseError(nil, err.Error())
+	}
+	_, _ = d.json.Discard(len(buf) + 1)
+	var x [8]byte
+	xs := x[0:8]
+	binary.LittleEndian.PutUint64(xs, uint64(epochMillis))
+	out = append(out, xs...)
+
+	return out, nil
+}
+
 // convertType starts after the opening quote of the `"$type"` key.  We need to
 // distinguish between Extended JSON $type or something else. We decode to a
 // scratch buffer and look for exactly "$type" and "$binary".
@@ -434,7 +456,12 @@ func (d *Decoder) convertType(out []byte, typeBytePos int) ([]byte, error) {
 	defer func() { d.scratchPool.Put(scratchP) }()
 	scratch := (*scratchP)[0:0]
 
+	// Keys of this object are checked against the key policy only if it
+	// isn't extended JSON.
+	rawKeyDepth := d.rawKeyDepth
+	d.rawKeyDepth = d.curDepth + 1
 	scratch, err = d.convertObject(scratch, topContainer)
+	d.rawKeyDepth = rawKeyDepth
 	if err != nil {
 		return nil, err
 	}
@@ -460,8 +487,7 @@ func (d *Decoder) convertType(out []byte, typeBytePos int) ([]byte, error) {
 	if sawBinary != 1 || sawType != 1 || sawOther != 0 ||
 		binaryValue.Type != bsontype.String || subTypeValue.Type != bsontype.String {
 		overwriteTypeByte(out, typeBytePos, bsonDocument)
-		out = append(out, scratch...)
-		return out, nil
+		return d.appendWithKeyPolicy(out, scratch)
 	}
 
 	// If we reach here, then confirmed this as a binary BSON type
@@ -622,7 +648,12 @@ func (d *Decoder) convertRegexOptionsSlowPath(out []byte, typeBytePos int) ([]by
 	defer func() { d.scratchPool.Put(scratchP) }()
 	scratch := (*scratchP)[0:0]
 
+	// Keys of this object are checked against the key policy only if it
+	// isn't extended JSON.
+	rawKeyDepth := d.rawKeyDepth
+	d.rawKeyDepth = d.curDepth + 1
 	scratch, err = d.convertObject(scratch, topContainer)
+	d.rawKeyDepth = rawKeyDepth
 	if err != nil {
 		return nil, err
 	}
@@ -648,8 +679,7 @@ func (d *Decoder) convertRegexOptionsSlowPath(out []byte, typeBytePos int) ([]by
 	if sawRegex != 1 || sawOptions != 1 || sawOther != 0 ||
 		regexValue.Type != bsontype.String || optionsValue.Type != bsontype.String {
 		overwriteTypeByte(out, typeBytePos, bsonDocument)
-		out = append(out, scratch...)
-		return out, nil
+		return d.appendWithKeyPolicy(out, scratch)
 	}
 
 	// If we reach here, then confirmed this as a regular expression BSON type.
@@ -659,7 +689,7 @@ func (d *Decoder) convertRegexOptionsSlowPath(out []byte, typeBytePos int) ([]by
 	out = append(out, nullByte)
 
 	opts := []byte(optionsValue.StringValue())
-	if len(opts) > 1 {
+	if len(opts) > 0 {
 		err = sortOptions(opts)
 		if err != nil {
 			return nil, err
@@ -798,26 +828,7 @@ func (d *Decoder) convertV2Binary(out []byte) ([]byte, error) {
 		}
 	}
 
-	// write length of binary payload (added length of the output minux 5 bytes
-	// for length+type)
-	binLength := len(out) - lengthPos - 5
-
-	// For binary subtype 2, the byte payload must have the length repeated,
-	// so we need to rearrange the output data.  We don't do this by default
-	// so that the common case avoids copies.
-	if subType == 2 {
-		// Extend out by size of length bytes, then slide data down.
-		out = append(out, emptyLength...)
-		payloadPos := lengthPos + 5
-		for i := len(out) - 5; i >= payloadPos; i-- {
-			out[i+4] = out[i]
-		}
-		// Write length to start of payload and increment outer length
-		overwriteLength(out, payloadPos, binLength)
-		binLength += 4
-	}
-
-	overwriteLength(out, lengthPos, binLength)
+	out = writeBinaryLength(out, lengthPos, subType)
 
 	// Must end with document terminator
 	err := d.readObjectTerminator()
@@ -876,6 +887,14 @@ func (d *Decoder) convertV1Binary(out []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	out = writeBinaryLength(out, lengthPos, subType)
+
+	return out, nil
+}
+
+// writeBinaryLength writes the length of a binary payload that was appended
+// after a length placeholder and a subtype byte.
+func writeBinaryLength(out []byte, lengthPos int, subType byte) []byte {
 	// write length of binary payload (added length of the output minux 5 bytes
 	// for length+type)
 	binLength := len(out) - lengthPos - 5
@@ -897,7 +916,7 @@ func (d *Decoder) convertV1Binary(out []byte) ([]byte, error) {
 
 	overwriteLength(out, lengthPos, binLength)
 
-	return out, nil
+	return out
 }
 
 // convertUUID starts after the `"$uuid"` key.  The value must be a quoted
@@ -925,7 +944,7 @@ func (d *Decoder) convertUUID(out []byte) ([]byte, error) {
 	// Process buf into UUID, allowing any formats the uuid library recognizes.
 	u, err := uuid.ParseBytes(buf)
 	if err != nil {
-		return nil, d.parseError(buf, fmt.Sprintf("uuid conversion: %v", err))
+		return nil, d.parseErrorAt(d.json.position(), buf, fmt.Sprintf("uuid conversion: %v", err))
 	}
 	xs, _ := u.MarshalBinary()
 
@@ -1104,6 +1123,7 @@ func (d *Decoder) convertDBPointer(out []byte) ([]byte, error) {
 			// Value must be of type object ID.  Read the value into a temporary
 			// buffer, reserving the first byte for discovered type.  Copy the
 			// start of the reader for error reporting.
+			peekPos := d.json.position()
 			peek := d.copyPeek(parseErrorContextLength)
 
 			id, err = d.convertValue(id, 0)
@@ -1111,7 +1131,7 @@ func (d *Decoder) convertDBPointer(out []byte) ([]byte, error) {
 				return nil, err
 			}
 			if id[0] != bsonObjectID {
-				return nil, d.parseError(peek, fmt.Sprintf("$dbPointer.$id must be BSON type %d, not type %d", bsonObjectID, id[0]))
+				return nil, d.parseErrorAt(peekPos, peek, fmt.Sprintf("$dbPointer.$id must be BSON type %d, not type %d", bsonObjectID, id[0]))
 			}
 
 			// If we haven't seen the other key, we expect to see a separator.
@@ -1161,6 +1181,23 @@ func (d *Decoder) convertNumberInt(out []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	out, err = d.convertInt32String(out)
+	if err != nil {
+		return nil, err
+	}
+
+	// Must end with document terminator.
+	err = d.readObjectTerminator()
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// convertInt32String starts after the opening quote of a string holding an
+// int32 and consumes the string and closing quote.
+func (d *Decoder) convertInt32String(out []byte) ([]byte, error) {
 	// Peek at least 2 and up to 12 chars (for '-2147483648' plus closing quote).
 	buf, err := d.peekBoundedQuote(2, 12, "int32")
 	if err != nil {
@@ -1179,12 +1216,6 @@ func (d *Decoder) convertNumberInt(out []byte) ([]byte, error) {
 	// Discard buffer and trailing quote
 	_, _ = d.json.Discard(len(buf) + 1)
 
-	// Must end with document terminator.
-	err = d.readObjectTerminator()
-	if err != nil {
-		return nil, err
-	}
-
 	return out, nil
 }
 
@@ -1354,6 +1385,23 @@ func (d *Decoder) convertNumberLong(out []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	out, err = d.convertInt64String(out)
+	if err != nil {
+		return nil, err
+	}
+
+	// Must end with document terminator.
+	err = d.readObjectTerminator()
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// convertInt64String starts after the opening quote of a string holding an
+// int64 and consumes the string and closing quote.
+func (d *Decoder) convertInt64String(out []byte) ([]byte, error) {
 	// Peek at least 2 and up to 21 chars (for '-9223372036854775808' plus closing quote).
 	buf, err := d.peekBoundedQuote(2, 21, "int64")
 	if err != nil {
@@ -1372,12 +1420,6 @@ func (d *Decoder) convertNumberLong(out []byte) ([]byte, error) {
 	// Discard buffer and trailing quote
 	_, _ = d.json.Discard(len(buf) + 1)
 
-	// Must end with document terminator.
-	err = d.readObjectTerminator()
-	if err != nil {
-		return nil, err
-	}
-
 	return out, nil
 }
 
@@ -1441,6 +1483,23 @@ func (d *Decoder) convertNumberDecimal(out []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	out, err = d.convertDecimalString(out)
+	if err != nil {
+		return nil, err
+	}
+
+	// Must end with document terminator.
+	err = d.readObjectTerminator()
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// convertDecimalString starts after the opening quote of a string holding a
+// Decimal128 and consumes the string and closing quote.
+func (d *Decoder) convertDecimalString(out []byte) ([]byte, error) {
 	// Peek at least 2 and up to decimalPeekWidth chars (for long '0.0000...1' plus closing quote).
 	buf, err := d.peekBoundedQuote(2, decimalPeekWidth, "decimal128")
 	if err != nil {
@@ -1452,23 +1511,11 @@ func (d *Decoder) convertNumberDecimal(out []byte) ([]byte, error) {
 		return nil, d.parseError(nil, "can't parse Decimal128")
 	}
 
-	hi, lo := d128.GetBytes()
-	var x [8]byte
-	xs := x[0:8]
-	binary.LittleEndian.PutUint64(xs, lo)
-	out = append(out, xs...)
-	binary.LittleEndian.PutUint64(xs, hi)
-	out = append(out, xs...)
+	out = appendDecimal128(out, d128)
 
 	// Discard buffer and trailing quote
 	_, _ = d.json.Discard(len(buf) + 1)
 
-	// Must end with document terminator.
-	err = d.readObjectTerminator()
-	if err != nil {
-		return nil, err
-	}
-
 	return out, nil
 }
 
@@ -1564,6 +1611,7 @@ func (d *Decoder) convertRegularExpression(out []byte) ([]byte, error) {
 
 			// Copy options to separate data for validation/sorting.  Keep
 			// a copy of the original reader for error reporting.
+			peekPos := d.json.position()
 			peek := d.copyPeek(parseErrorContextLength)
 
 			scratchP := d.scratchPool.Get().(*[]byte)
@@ -1582,7 +1630,7 @@ func (d *Decoder) convertRegularExpression(out []byte) ([]byte, error) {
 			if len(options) > 1 {
 				err = sortOptions(options[0 : len(options)-1])
 				if err != nil {
-					return nil, d.parseError(peek, err.Error())
+					return nil, d.parseErrorAt(peekPos, peek, err.Error())
 				}
 			}
 
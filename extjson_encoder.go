@@ -0,0 +1,306 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExtJSONMode selects how an Encoder writes BSON types that have no JSON
+// equivalent.
+type ExtJSONMode int
+
+// ExtJSONMode constants.  See the Extended JSON v2 specification for the
+// differences between the canonical and relaxed formats:
+// https://github.com/mongodb/specifications/blob/master/source/extended-json.rst
+const (
+	// PlainJSON writes only JSON types.  Other BSON types are an error.
+	PlainJSON ExtJSONMode = iota
+	// RelaxedExtJSON writes numbers and recent dates in a natural JSON form
+	// and other non-JSON types as Extended JSON.
+	RelaxedExtJSON
+	// CanonicalExtJSON writes all numbers, dates and other non-JSON types as
+	// type-preserving Extended JSON.
+	CanonicalExtJSON
+)
+
+// The latest datetime that relaxed Extended JSON writes as an ISO-8601 string,
+// 9999-12-31T23:59:59.999Z, as milliseconds since the epoch.
+const maxRelaxedDateMillis = 253402300799999
+
+// encodeExtJSON is called from encodeValue for BSON types that only have an
+// Extended JSON representation.  It works like encodeValue.
+func (e *Encoder) encodeExtJSON(out []byte, bsonType byte, in []byte) ([]byte, int, error) {
+	switch bsonType {
+	case bsonBinary:
+		return encodeBinary(out, in)
+	case bsonUndefined:
+		out = append(out, `{"$undefined":true}`...)
+		return out, 0, nil
+	case bsonObjectID:
+		if len(in) < 12 {
+			return nil, 0, newBSONError("objectID truncated")
+		}
+		out = encodeOID(out, in[0:12])
+		return out, 12, nil
+	case bsonDateTime:
+		if len(in) < 8 {
+			return nil, 0, newBSONError("datetime truncated")
+		}
+		out = e.encodeDate(out, int64(binary.LittleEndian.Uint64(in)))
+		return out, 8, nil
+	case bsonRegex:
+		return encodeRegularExpression(out, in)
+	case bsonDBPointer:
+		return encodeDBPointer(out, in)
+	case bsonCode:
+		str, n, err := readString(in)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, `{"$code":`...)
+		out = appendJSONString(out, str)
+		out = append(out, '}')
+		return out, n, nil
+	case bsonSymbol:
+		str, n, err := readString(in)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, `{"$symbol":`...)
+		out = appendJSONString(out, str)
+		out = append(out, '}')
+		return out, n, nil
+	case bsonCodeWithScope:
+		return e.encodeCodeWithScope(out, in)
+	case bsonTimestamp:
+		if len(in) < 8 {
+			return nil, 0, newBSONError("timestamp truncated")
+		}
+		out = append(out, `{"$timestamp":{"t":`...)
+		out = strconv.AppendUint(out, uint64(binary.LittleEndian.Uint32(in[4:8])), 10)
+		out = append(out, `,"i":`...)
+		out = strconv.AppendUint(out, uint64(binary.LittleEndian.Uint32(in[0:4])), 10)
+		out = append(out, '}', '}')
+		return out, 8, nil
+	case bsonDecimal128:
+		if len(in) < 16 {
+			return nil, 0, newBSONError("decimal128 truncated")
+		}
+		lo := binary.LittleEndian.Uint64(in[0:8])
+		hi := binary.LittleEndian.Uint64(in[8:16])
+		out = append(out, `{"$numberDecimal":"`...)
+		out = append(out, primitive.NewDecimal128(hi, lo).String()...)
+		out = append(out, '"', '}')
+		return out, 16, nil
+	case bsonMinKey:
+		out = append(out, `{"$minKey":1}`...)
+		return out, 0, nil
+	case bsonMaxKey:
+		out = append(out, `{"$maxKey":1}`...)
+		return out, 0, nil
+	default:
+		return nil, 0, newBSONError("unknown BSON type 0x%02x", bsonType)
+	}
+}
+
+// encodeNumberDouble writes a `$numberDouble` object.  Non-finite values use
+// the special strings from the Extended JSON specification.
+func encodeNumberDouble(out []byte, f float64) []byte {
+	out = append(out, `{"$numberDouble":"`...)
+	switch {
+	case math.IsInf(f, 1):
+		out = append(out, "Infinity"...)
+	case math.IsInf(f, -1):
+		out = append(out, "-Infinity"...)
+	case math.IsNaN(f):
+		out = append(out, "NaN"...)
+	default:
+		out = appendDouble(out, f)
+	}
+	out = append(out, '"', '}')
+	return out
+}
+
+// encodeNumberInt writes a `$numberInt` or `$numberLong` object, depending on
+// the key given.
+func encodeNumberInt(out []byte, key []byte, n int64) []byte {
+	out = append(out, '{', '"')
+	out = append(out, key...)
+	out = append(out, '"', ':', '"')
+	out = strconv.AppendInt(out, n, 10)
+	out = append(out, '"', '}')
+	return out
+}
+
+// encodeOID writes an `$oid` object for a 12 byte ObjectID.
+func encodeOID(out []byte, oid []byte) []byte {
+	out = append(out, `{"$oid":"`...)
+	out = appendHex(out, oid)
+	out = append(out, '"', '}')
+	return out
+}
+
+// encodeDate writes a `$date` object.  In relaxed mode, dates from 1970
+// through 9999 are written as ISO-8601 strings.
+func (e *Encoder) encodeDate(out []byte, epochMillis int64) []byte {
+	out = append(out, `{"$date":`...)
+	if e.extJSONMode == RelaxedExtJSON && epochMillis >= 0 && epochMillis <= maxRelaxedDateMillis {
+		t := time.Unix(epochMillis/1e3, epochMillis%1e3*1e6).UTC()
+		out = append(out, '"')
+		out = t.AppendFormat(out, timeFormats[0])
+		out = append(out, '"')
+	} else {
+		out = encodeNumberInt(out, jsonNumberLong, epochMillis)
+	}
+	out = append(out, '}')
+	return out
+}
+
+// encodeBinary writes a `$binary` object.  For the old binary subtype 0x02,
+// the repeated inner length is validated and removed from the payload.
+func encodeBinary(out []byte, in []byte) ([]byte, int, error) {
+	if len(in) < 5 {
+		return nil, 0, newBSONError("binary truncated")
+	}
+	length := int(int32(binary.LittleEndian.Uint32(in)))
+	if length < 0 || length > len(in)-5 {
+		return nil, 0, newBSONError("binary length %d invalid", length)
+	}
+	subType := in[4]
+	payload := in[5 : 5+length]
+
+	if subType == 2 {
+		if len(payload) < 4 {
+			return nil, 0, newBSONError("binary subtype 0x02 truncated")
+		}
+		innerLength := int(int32(binary.LittleEndian.Uint32(payload)))
+		if innerLength != len(payload)-4 {
+			return nil, 0, newBSONError("binary subtype 0x02 length %d invalid", innerLength)
+		}
+		payload = payload[4:]
+	}
+
+	out = append(out, `{"$binary":{"base64":"`...)
+	out = appendBase64(out, payload)
+	out = append(out, `","subType":"`...)
+	out = append(out, hexDigits[subType>>4], hexDigits[subType&0xF])
+	out = append(out, '"', '}', '}')
+
+	return out, 5 + length, nil
+}
+
+// encodeRegularExpression writes a `$regularExpression` object from the
+// pattern and options C strings.
+func encodeRegularExpression(out []byte, in []byte) ([]byte, int, error) {
+	patternLength := bytes.IndexByte(in, nullByte)
+	if patternLength < 0 {
+		return nil, 0, newBSONError("regular expression pattern not null terminated")
+	}
+	optionsLength := bytes.IndexByte(in[patternLength+1:], nullByte)
+	if optionsLength < 0 {
+		return nil, 0, newBSONError("regular expression options not null terminated")
+	}
+	pattern := in[0:patternLength]
+	options := in[patternLength+1 : patternLength+1+optionsLength]
+
+	out = append(out, `{"$regularExpression":{"pattern":`...)
+	out = appendJSONString(out, pattern)
+	out = append(out, `,"options":`...)
+	out = appendJSONString(out, options)
+	out = append(out, '}', '}')
+
+	return out, patternLength + optionsLength + 2, nil
+}
+
+// encodeDBPointer writes a `$dbPointer` object from the namespace string and
+// ObjectID.
+func encodeDBPointer(out []byte, in []byte) ([]byte, int, error) {
+	ref, n, err := readString(in)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(in)-n < 12 {
+		return nil, 0, newBSONError("dbPointer objectID truncated")
+	}
+
+	out = append(out, `{"$dbPointer":{"$ref":`...)
+	out = appendJSONString(out, ref)
+	out = append(out, `,"$id":`...)
+	out = encodeOID(out, in[n:n+12])
+	out = append(out, '}', '}')
+
+	return out, n + 12, nil
+}
+
+// encodeCodeWithScope writes a `$code` object with `$scope`.  The total length
+// must exactly cover the code string and scope document.
+func (e *Encoder) encodeCodeWithScope(out []byte, in []byte) ([]byte, int, error) {
+	if len(in) < 4 {
+		return nil, 0, newBSONError("code with scope truncated")
+	}
+	length := int(int32(binary.LittleEndian.Uint32(in)))
+	// Minimum is total length, an empty string, and an empty document.
+	if length < 14 || length > len(in) {
+		return nil, 0, newBSONError("code with scope length %d invalid", length)
+	}
+	in = in[0:length]
+
+	code, n, err := readString(in[4:])
+	if err != nil {
+		return nil, 0, err
+	}
+	out = append(out, `{"$code":`...)
+	out = appendJSONString(out, code)
+	out = append(out, `,"$scope":`...)
+
+	out, m, err := e.encodeDocument(out, in[4+n:], false)
+	if err != nil {
+		return nil, 0, err
+	}
+	if 4+n+m != length {
+		return nil, 0, newBSONError("code with scope length %d invalid", length)
+	}
+	out = append(out, '}')
+
+	return out, length, nil
+}
+
+// appendHex appends the lower-case hex encoding of the input.
+func appendHex(out []byte, in []byte) []byte {
+	start := len(out)
+	out = grow(out, hex.EncodedLen(len(in)))
+	hex.Encode(out[start:], in)
+	return out
+}
+
+// appendBase64 appends the padded, standard base64 encoding of the input.
+func appendBase64(out []byte, in []byte) []byte {
+	start := len(out)
+	out = grow(out, base64.StdEncoding.EncodedLen(len(in)))
+	base64.StdEncoding.Encode(out[start:], in)
+	return out
+}
+
+// grow extends the length of the buffer by n bytes, reallocating if needed,
+// like `append` would.
+func grow(out []byte, n int) []byte {
+	if cap(out)-len(out) < n {
+		bigger := make([]byte, len(out), 2*cap(out)+n)
+		copy(bigger, out)
+		out = bigger
+	}
+	return out[0 : len(out)+n]
+}
@@ -15,8 +15,12 @@ import (
 	"io"
 	"io/ioutil"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 // TestExtJSON tests a targeted subset of the MongoDB BSON corpus tests with a
@@ -288,6 +292,16 @@ func TestExtJSON(t *testing.T) {
 			input:  `{"a" : {"$options" : "im", "$regex" : "abc"}}`,
 			output: "0F0000000B610061626300696D0000",
 		},
+		{
+			label:  "$regex string, single option",
+			input:  `{"a" : {"$regex" : "abc", "$options" : "i"}}`,
+			output: "0E0000000B610061626300690000",
+		},
+		{
+			label:  "$regex string, keys reversed, single option",
+			input:  `{"a" : {"$options" : "i", "$regex" : "abc"}}`,
+			output: "0E0000000B610061626300690000",
+		},
 		{
 			label:  "$regex string, keys reversed, options unsorted",
 			input:  `{"a" : {"$options" : "mi", "$regex" : "abc"}}`,
@@ -534,11 +548,17 @@ type parseErrorCorpusCase struct {
 	Input       string `json:"string"`
 }
 
+type decodeErrorCorpusCase struct {
+	Description string `json:"description"`
+	BSON        string `json:"bson"`
+}
+
 type corpusFile struct {
-	Description string                 `json:"description"`
-	TestKey     string                 `json:"test_key"`
-	Valid       []validCorpusCase      `json:"valid"`
-	ParseErrors []parseErrorCorpusCase `json:"parseErrors"`
+	Description  string                  `json:"description"`
+	TestKey      string                  `json:"test_key"`
+	Valid        []validCorpusCase       `json:"valid"`
+	DecodeErrors []decodeErrorCorpusCase `json:"decodeErrors"`
+	ParseErrors  []parseErrorCorpusCase  `json:"parseErrors"`
 }
 
 func TestBSONCorpus(t *testing.T) {
@@ -566,6 +586,9 @@ func TestBSONCorpus(t *testing.T) {
 			if testCase.Valid != nil {
 				testValidCorpusCases(t, f.Name(), testCase.Valid)
 			}
+			if testCase.DecodeErrors != nil {
+				testDecodeErrorCorpusCases(t, testCase.DecodeErrors)
+			}
 			if testCase.ParseErrors != nil {
 				testParseErrorCorpusCases(t, f.Name(), testCase.ParseErrors)
 			}
@@ -586,6 +609,12 @@ func testValidCorpusCases(t *testing.T, name string, cases []validCorpusCase) {
 				if c.DegenerateExtJSON != "" && !c.Lossy {
 					compareCorpusUnmarshal(t, c.DegenerateExtJSON, c.CanonicalBSON)
 				}
+				if c.CanonicalExtJSON != "" {
+					compareCorpusMarshal(t, c.CanonicalBSON, c.CanonicalExtJSON, CanonicalExtJSON)
+				}
+				if c.RelaxedExtJSON != "" {
+					compareCorpusMarshal(t, c.CanonicalBSON, c.RelaxedExtJSON, RelaxedExtJSON)
+				}
 				if c.RelaxedExtJSON != "" {
 					fromJibby, err := convertWithJibby([]byte(c.RelaxedExtJSON))
 					if err != nil {
@@ -637,6 +666,61 @@ func compareCorpusUnmarshal(t *testing.T, input string, output string) {
 	}
 }
 
+func compareCorpusMarshal(t *testing.T, input string, output string, mode ExtJSONMode) {
+	bson, err := hex.DecodeString(input)
+	if err != nil {
+		t.Fatalf("error decoding test input: %v", err)
+	}
+	var expect bytes.Buffer
+	err = json.Compact(&expect, unescapeNonASCII([]byte(output)))
+	if err != nil {
+		t.Fatalf("error compacting test output: %v", err)
+	}
+
+	out, err := MarshalExtJSON(bson, nil, mode)
+	if err != nil {
+		t.Fatalf("Marshal (mode %d): %v", mode, err)
+	}
+
+	if !bytes.Equal(out, expect.Bytes()) {
+		t.Fatalf("Marshal (mode %d) doesn't match expected:\nGot:    %s\nExpect: %s", mode, out, expect.String())
+	}
+}
+
+var nonASCIIEscapeRe = regexp.MustCompile(`\\u[0-9a-fA-F]{4}`)
+
+// unescapeNonASCII replaces `\uXXXX` escapes for non-ASCII, non-surrogate
+// characters with UTF-8, since Marshal only escapes control characters.
+func unescapeNonASCII(in []byte) []byte {
+	return nonASCIIEscapeRe.ReplaceAllFunc(in, func(esc []byte) []byte {
+		r, _ := strconv.ParseUint(string(esc[2:]), 16, 32)
+		if r < utf8.RuneSelf || utf16.IsSurrogate(rune(r)) {
+			return esc
+		}
+		return []byte(string(rune(r)))
+	})
+}
+
+func testDecodeErrorCorpusCases(t *testing.T, cases []decodeErrorCorpusCase) {
+	t.Run("decode errors", func(t *testing.T) {
+		for _, c := range cases {
+			c := c
+			t.Run(c.Description, func(t *testing.T) {
+				t.Parallel()
+				bson, err := hex.DecodeString(c.BSON)
+				if err != nil {
+					t.Fatalf("error decoding test input: %v", err)
+				}
+				_, err = MarshalExtJSON(bson, nil, CanonicalExtJSON)
+				if err == nil {
+					t.Fatalf("Expected error but got nil")
+				}
+				t.Log(err)
+			})
+		}
+	})
+}
+
 var skipParseErrorCases = []string{
 	"Bad DBRef",
 	"Bad $date (number, not string or hash)",
@@ -0,0 +1,262 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Framing determines how a Decoder expects top-level values to be delimited
+// in the input.
+type Framing int
+
+// Framing constants.
+const (
+	// AutoFraming expects a single top-level array if the input starts with
+	// '[' and otherwise values separated by optional white space.  This is
+	// the default.
+	AutoFraming Framing = iota
+	// NDJSONFraming expects newline-delimited JSON: each line has exactly one
+	// value.  A value that continues onto another line, more content after a
+	// value on its line, or an empty line is a ParseError.  A line may end
+	// with "\r\n" and the input may end without a newline.
+	NDJSONFraming
+	// ArrayFraming expects a single top-level array.  Any other input, or
+	// content after the end of the array, is a ParseError.
+	ArrayFraming
+	// SingleDocumentFraming expects a single top-level value.  Content after
+	// it is a ParseError and ends the stream.
+	SingleDocumentFraming
+	// JSONSeqFraming expects a JSON text sequence (RFC 7464), as used by the
+	// `application/json-seq` media type: each value is preceded by an ASCII
+	// record separator (RS, 0x1E) and usually followed by a newline.  Empty
+	// records are ignored.  After a ParseError, like for a truncated value,
+	// decoding always continues with the next record.
+	JSONSeqFraming
+)
+
+// recordSeparator starts each value in a JSON text sequence.
+const recordSeparator = 0x1E
+
+// allowsArray reports whether the framing treats a top-level array as a
+// stream of values.
+func (f Framing) allowsArray() bool {
+	return f == AutoFraming || f == ArrayFraming
+}
+
+// Framing sets how top-level values are delimited in the input, to catch
+// corrupt input early.  It must be called before the first call to Decode or
+// DecodeValue and can't be combined with StreamArray.
+//
+// NewDecoder checks for a top-level array before options can be set; with
+// NDJSONFraming or SingleDocumentFraming, a '[' it found is read again as the
+// start of a value.  ParseErrors report the line of an error, so for NDJSON
+// they identify the line with the malformed value.
+func (d *Decoder) Framing(f Framing) error {
+	if d.docCount > 0 || d.arrayFinished {
+		return errors.New("Framing must be called before decoding")
+	}
+	if f != AutoFraming && d.arrayPath != nil {
+		return errors.New("Framing can't be combined with StreamArray")
+	}
+
+	prev := d.framing
+	d.framing = f
+	if !d.started {
+		return nil
+	}
+	switch {
+	case d.arrayStarted && !f.allowsArray():
+		d.unreadArrayStart()
+	case !prev.allowsArray() && f.allowsArray():
+		err := d.detectArray()
+		if err != nil && err != io.EOF {
+			return newReadError(err)
+		}
+	}
+	d.updateCapture()
+
+	return nil
+}
+
+// unreadArrayStart puts back the opening bracket of a top-level array that
+// was consumed when the decoder started, so it is read again as the start of
+// a value.
+func (d *Decoder) unreadArrayStart() {
+	if d.json.r != d.buffer {
+		d.readThrough(d.json.r, d.offset(), d.json.r.Size())
+	}
+
+	// Input that was already buffered has to be read again after the bracket.
+	d.json.count()
+	offset := d.offset() - 1
+	buffered, _ := d.json.Peek(d.json.Buffered())
+	capturing := d.capture.capturing
+	d.capture.stopCapture()
+	d.capture.unread(append([]byte{'['}, buffered...))
+	d.buffer.Reset(d.capture)
+	d.json.moveTo(d.buffer, offset)
+	if capturing {
+		d.capture.startCapture()
+	}
+	d.arrayStarted = false
+}
+
+// finishTopValue consumes the input after a top-level value as the framing
+// requires.
+func (d *Decoder) finishTopValue() error {
+	switch d.framing {
+	case NDJSONFraming:
+		return d.readLineEnd()
+	case SingleDocumentFraming:
+		// No other value may follow, even if there's an error.
+		d.arrayFinished = true
+		return d.readInputEnd()
+	case JSONSeqFraming:
+		return d.readRecordEnd()
+	}
+
+	err := d.readArraySeparator()
+	if err == nil && d.arrayFinished && d.framing == ArrayFraming {
+		err = d.readInputEnd()
+	}
+	return err
+}
+
+// readInputEnd consumes white space and errors if the input doesn't end
+// after it.
+func (d *Decoder) readInputEnd() error {
+	ch, err := d.readAfterWS()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return newReadError(err)
+	}
+	return d.parseError([]byte{ch}, "expecting end of input")
+}
+
+// notArrayError returns the error for input that doesn't start with a
+// top-level array with ArrayFraming, and ends the stream.
+func (d *Decoder) notArrayError() error {
+	d.arrayFinished = true
+	ch, err := d.readAfterWS()
+	if err != nil {
+		return newReadError(err)
+	}
+	return d.parseError([]byte{ch}, "expecting top-level array")
+}
+
+// readAfterLineSpace discards white space other than newlines and returns the
+// next character.  Any error that occurs is returned without wrapping.
+func (d *Decoder) readAfterLineSpace() (byte, error) {
+	for {
+		ch, err := d.json.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch ch {
+		case ' ', '\t', '\r':
+		default:
+			return ch, nil
+		}
+	}
+}
+
+// readLineEnd checks that a value with NDJSONFraming is on a single line and
+// consumes the rest of the line, which may only have white space.
+func (d *Decoder) readLineEnd() error {
+	if d.json.position().lines > d.docPos.lines {
+		nl := d.json.firstNL
+		line := d.docPos.lines + 1
+		column := int(nl - d.docPos.lastNL)
+		return &ParseError{
+			Offset:   nl,
+			Line:     line,
+			Column:   column,
+			Document: d.docCount - 1,
+			msg:      fmt.Sprintf("parse error: NDJSON value continues past the end of line %d", line),
+		}
+	}
+
+	ch, err := d.readAfterLineSpace()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return newReadError(err)
+	}
+	if ch != '\n' {
+		return d.parseError([]byte{ch}, "expecting end of line after NDJSON value")
+	}
+	return nil
+}
+
+// readRecordStart consumes white space and record separators before a value
+// in a JSON text sequence and returns the first character of the value and
+// whether it was preceded by a separator.  Any error is returned without
+// wrapping.
+func (d *Decoder) readRecordStart() (byte, bool, error) {
+	sawRS := d.recordOpen
+	d.recordOpen = false
+	for {
+		ch, err := d.readAfterWS()
+		if err != nil {
+			return 0, false, err
+		}
+		if ch != recordSeparator {
+			return ch, sawRS, nil
+		}
+		sawRS = true
+	}
+}
+
+// readRecordEnd consumes white space after a value in a JSON text sequence
+// and checks that the record ends there.
+func (d *Decoder) readRecordEnd() error {
+	ch, err := d.readAfterWS()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return newReadError(err)
+	}
+	if ch != recordSeparator {
+		return d.parseError([]byte{ch}, "expecting record separator after value")
+	}
+	d.recordOpen = true
+	return nil
+}
+
+// skipRecord positions the input after the record separator that ends the
+// record of a JSON text sequence containing an offset.  It returns the offset
+// of the separator, or of the end of the input if there is none.
+func (d *Decoder) skipRecord(start int64) (int64, error) {
+	// A value ends at a separator at the latest, so a separator that was
+	// consumed is the last byte consumed.
+	if rs := d.capture.separatorAfter(start); rs >= 0 && rs < d.offset() {
+		d.recordOpen = true
+		return rs, nil
+	}
+
+	for {
+		_, err := d.json.ReadSlice(recordSeparator)
+		if err == nil {
+			d.recordOpen = true
+			return d.offset() - 1, nil
+		}
+		if err == io.EOF {
+			return d.offset(), nil
+		}
+		if err != bufio.ErrBufferFull {
+			return 0, newReadError(err)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFraming(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label   string
+		framing Framing
+		input   string
+		output  []string
+		errStr  string
+		errLine int
+	}
+
+	cases := []testCase{
+		{
+			label:   "auto array",
+			framing: AutoFraming,
+			input:   `[{"a":1},{"a":2}] {"x":1}`,
+			output:  []string{`{"a":1}`, `{"a":2}`},
+		},
+		{
+			label:   "auto stream",
+			framing: AutoFraming,
+			input:   "{\"a\":1}\n\n{\n\"a\":2}",
+			output:  []string{`{"a":1}`, `{"a":2}`},
+		},
+		{
+			label:   "NDJSON",
+			framing: NDJSONFraming,
+			input:   "{\"a\":1}\r\n  {\"a\":2}  \n{\"a\":3}\n",
+			output:  []string{`{"a":1}`, `{"a":2}`, `{"a":3}`},
+		},
+		{
+			label:   "NDJSON without final newline",
+			framing: NDJSONFraming,
+			input:   "{\"a\":1}\n{\"a\":2}",
+			output:  []string{`{"a":1}`, `{"a":2}`},
+		},
+		{
+			label:   "NDJSON empty line",
+			framing: NDJSONFraming,
+			input:   "{\"a\":1}\n\n{\"a\":2}\n",
+			output:  []string{`{"a":1}`},
+			errStr:  "empty line in NDJSON",
+			errLine: 2,
+		},
+		{
+			label:   "NDJSON two values on a line",
+			framing: NDJSONFraming,
+			input:   "{\"a\":1}\n{\"a\":2} {\"a\":3}\n",
+			output:  []string{`{"a":1}`},
+			errStr:  "expecting end of line after NDJSON value",
+			errLine: 2,
+		},
+		{
+			label:   "NDJSON value spans lines",
+			framing: NDJSONFraming,
+			input:   "{\"a\":1}\n{\"a\":\n2}\n",
+			output:  []string{`{"a":1}`},
+			errStr:  "NDJSON value continues past the end of line 2",
+			errLine: 2,
+		},
+		{
+			label:   "NDJSON array",
+			framing: NDJSONFraming,
+			input:   `[{"a":1}]`,
+			output:  []string{},
+			errStr:  "Decode only supports object decoding",
+			errLine: 1,
+		},
+		{
+			label:   "array",
+			framing: ArrayFraming,
+			input:   "\n[{\"a\":1},\n{\"a\":2}]\n",
+			output:  []string{`{"a":1}`, `{"a":2}`},
+		},
+		{
+			label:   "empty array",
+			framing: ArrayFraming,
+			input:   `[]`,
+			output:  []string{},
+		},
+		{
+			label:   "array not found",
+			framing: ArrayFraming,
+			input:   `{"a":1}`,
+			output:  []string{},
+			errStr:  "expecting top-level array",
+			errLine: 1,
+		},
+		{
+			label:   "content after array",
+			framing: ArrayFraming,
+			input:   "[{\"a\":1}]\n{\"a\":2}",
+			output:  []string{},
+			errStr:  "expecting end of input",
+			errLine: 2,
+		},
+		{
+			label:   "content after empty array",
+			framing: ArrayFraming,
+			input:   "[] 1",
+			output:  []string{},
+			errStr:  "expecting end of input",
+			errLine: 1,
+		},
+		{
+			label:   "single document",
+			framing: SingleDocumentFraming,
+			input:   "\n {\"a\":1} \n",
+			output:  []string{`{"a":1}`},
+		},
+		{
+			label:   "content after single document",
+			framing: SingleDocumentFraming,
+			input:   "{\"a\":1}\n{\"a\":2}",
+			output:  []string{},
+			errStr:  "expecting end of input",
+			errLine: 2,
+		},
+		{
+			label:   "single array",
+			framing: SingleDocumentFraming,
+			input:   `[{"a":1}]`,
+			output:  []string{},
+			errStr:  "Decode only supports object decoding",
+			errLine: 1,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		for _, fromReader := range []bool{false, true} {
+			fromReader := fromReader
+			label := c.label
+			if fromReader {
+				label += " from reader"
+			}
+			t.Run(label, func(t *testing.T) {
+				t.Parallel()
+
+				var jib *Decoder
+				if fromReader {
+					jib = NewDecoderFromReader(strings.NewReader(c.input))
+				} else {
+					var err error
+					jib, err = NewDecoder(bufio.NewReader(strings.NewReader(c.input)))
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+				err := jib.Framing(c.framing)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				output := make([]string, 0)
+				for {
+					var buf []byte
+					buf, err = jib.Decode(nil)
+					if err != nil {
+						break
+					}
+					json, err := MarshalExtJSON(buf, nil, RelaxedExtJSON)
+					if err != nil {
+						t.Fatal(err)
+					}
+					output = append(output, string(json))
+				}
+				if !reflect.DeepEqual(output, c.output) {
+					t.Errorf("expected %q, but got %q", c.output, output)
+				}
+
+				if c.errStr == "" {
+					if err != io.EOF {
+						t.Errorf("expected io.EOF, but got %v", err)
+					}
+					return
+				}
+				if err == nil || !strings.Contains(err.Error(), c.errStr) {
+					t.Fatalf("expected error with '%s', but got %v", c.errStr, err)
+				}
+				var pe *ParseError
+				if !errors.As(err, &pe) {
+					t.Fatalf("expected *ParseError, but got %T", err)
+				}
+				if pe.Line != c.errLine {
+					t.Errorf("expected error on line %d, but got line %d", c.errLine, pe.Line)
+				}
+			})
+		}
+	}
+}
+
+func TestFramingOptions(t *testing.T) {
+	t.Parallel()
+
+	// Switching away from and back to array framing finds the array again.
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(`[{"a":1}]`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jib.Recover(true)
+	for _, f := range []Framing{SingleDocumentFraming, ArrayFraming} {
+		err = jib.Framing(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = jib.Decode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jib.Decode(nil)
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, but got %v", err)
+	}
+
+	err = jib.Framing(AutoFraming)
+	if err == nil {
+		t.Errorf("expected error setting framing after decoding, but got none")
+	}
+
+	jib = NewDecoderFromReader(strings.NewReader(`{"data":[]}`))
+	err = jib.StreamArray("/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = jib.Framing(NDJSONFraming)
+	if err == nil {
+		t.Errorf("expected error combining Framing with StreamArray, but got none")
+	}
+}
+
+func TestJSONSeqFraming(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label   string
+		input   string
+		recover bool
+		output  []string
+		errStrs []string
+		skipped []string
+	}
+
+	cases := []testCase{
+		{
+			label:  "records",
+			input:  "\x1e{\"a\":1}\n\x1e{\"a\":2}\n",
+			output: []string{`{"a":1}`, `{"a":2}`},
+		},
+		{
+			label:  "empty records and no final newline",
+			input:  "\x1e\x1e{\"a\":1}\x1e \x1e{\"a\":2}",
+			output: []string{`{"a":1}`, `{"a":2}`},
+		},
+		{
+			label:   "truncated record",
+			input:   "\x1e{\"a\":1}\n\x1e{\"a\":[2,\x1e{\"a\":3}\n",
+			output:  []string{`{"a":1}`, `{"a":3}`},
+			errStrs: []string{"invalid character"},
+		},
+		{
+			label:   "truncated string",
+			input:   "\x1e{\"a\":\"xy\x1e{\"a\":3}\n",
+			output:  []string{`{"a":3}`},
+			errStrs: []string{"control characters not allowed"},
+		},
+		{
+			label:   "truncated number",
+			input:   "\x1e{\"a\":1\x1e{\"a\":2}\n",
+			output:  []string{`{"a":2}`},
+			errStrs: []string{"int conversion"},
+		},
+		{
+			label:   "missing separator",
+			input:   "{\"a\":1}\n\x1e{\"a\":2}\n",
+			output:  []string{`{"a":2}`},
+			errStrs: []string{"expecting record separator"},
+		},
+		{
+			label:   "content after value",
+			input:   "\x1e{\"a\":1} x\n\x1e{\"a\":2}\n",
+			output:  []string{`{"a":2}`},
+			errStrs: []string{"expecting record separator after value"},
+		},
+		{
+			label:   "truncated last record",
+			input:   "\x1e{\"a\":1}\n\x1e{\"a\":",
+			output:  []string{`{"a":1}`},
+			errStrs: []string{"unexpected EOF"},
+		},
+		{
+			label:   "skipped text with recovery",
+			input:   "\x1e{\"a\":1}\n\x1e{\"a\":[2,\x1e{\"a\":3}\n",
+			recover: true,
+			output:  []string{`{"a":1}`, `{"a":3}`},
+			errStrs: []string{"invalid character"},
+			skipped: []string{`{"a":[2,`},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(c.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jib.Recover(c.recover)
+			err = jib.Framing(JSONSeqFraming)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output := make([]string, 0)
+			errStrs := make([]string, 0)
+			skipped := make([]string, 0)
+			for {
+				buf, err := jib.Decode(nil)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					errStrs = append(errStrs, err.Error())
+					var pe *ParseError
+					if errors.As(err, &pe) && pe.Skipped != nil {
+						skipped = append(skipped, string(pe.Skipped))
+					}
+					continue
+				}
+				json, err := MarshalExtJSON(buf, nil, RelaxedExtJSON)
+				if err != nil {
+					t.Fatal(err)
+				}
+				output = append(output, string(json))
+			}
+
+			if !reflect.DeepEqual(output, c.output) {
+				t.Errorf("expected %q, but got %q", c.output, output)
+			}
+			if len(errStrs) != len(c.errStrs) {
+				t.Fatalf("expected errors with %q, but got %q", c.errStrs, errStrs)
+			}
+			for i := range errStrs {
+				if !strings.Contains(errStrs[i], c.errStrs[i]) {
+					t.Errorf("expected error with '%s', but got %s", c.errStrs[i], errStrs[i])
+				}
+			}
+			if c.skipped != nil && !reflect.DeepEqual(skipped, c.skipped) {
+				t.Errorf("expected skipped %q, but got %q", c.skipped, skipped)
+			}
+		})
+	}
+}
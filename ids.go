@@ -0,0 +1,95 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bytes"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// IDPolicy determines whether a Decoder adds an `_id` field to documents.
+type IDPolicy int
+
+// IDPolicy constants.
+const (
+	// KeepIDs converts documents as they are.  This is the default.
+	KeepIDs IDPolicy = iota
+	// GenerateIDs adds a new ObjectID `_id` as the first field of a document
+	// without an `_id`.  An existing `_id` is left where it is.
+	GenerateIDs
+	// GenerateIDsFirst is like GenerateIDs, but also moves an existing `_id`
+	// to be the first field of the document.
+	GenerateIDsFirst
+)
+
+// IDPolicy sets whether to add an `_id` field to the top-level documents from
+// Decode, like a MongoDB driver does when inserting them.  The `_id` is
+// checked after key renames, duplicate key handling and projection, so a
+// document that has its `_id` projected out gets a new one.  Values from
+// DecodeValue are never changed.
+func (d *Decoder) IDPolicy(policy IDPolicy) {
+	d.idPolicy = policy
+}
+
+var idKey = []byte("_id")
+
+// idPlaceholder is an ObjectID `_id` element with a zero value, reserved at
+// the start of a document until the document has been converted.
+var idPlaceholder = []byte{bsonObjectID, '_', 'i', 'd', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+// generatesID reports whether an `_id` must be added to an object being
+// converted.
+func (d *Decoder) generatesID(outerTypeBytePos int) bool {
+	return d.idPolicy != KeepIDs && outerTypeBytePos == topContainer && d.curDepth == 1
+}
+
+// finishID fills in the placeholder at idPos with a new ObjectID if none of
+// the elements after it is an `_id`.  Otherwise, it removes the placeholder
+// and, if required, moves the `_id` element to its place.  The elements must
+// be complete, but the document must not yet be terminated.
+func (d *Decoder) finishID(out []byte, idPos int) []byte {
+	start := idPos + len(idPlaceholder)
+	pos, size := start, 0
+	elements := out[start:]
+	for len(elements) > 0 {
+		elem, rest, ok := bsoncore.ReadElement(elements)
+		if !ok {
+			break
+		}
+		if bytes.Equal(elem.KeyBytes(), idKey) {
+			size = len(elem)
+			break
+		}
+		pos += len(elem)
+		elements = rest
+	}
+
+	if size == 0 {
+		oid := primitive.NewObjectID()
+		copy(out[idPos+len(idPlaceholder)-len(oid):], oid[:])
+		return out
+	}
+
+	// Remove the placeholder.
+	n := copy(out[idPos:], out[start:])
+	out = out[0 : idPos+n]
+	pos -= len(idPlaceholder)
+
+	// Rotate the `_id` element to the front.
+	if d.idPolicy == GenerateIDsFirst && pos > idPos {
+		scratchP := d.scratchPool.Get().(*[]byte)
+		scratch := append((*scratchP)[0:0], out[pos:pos+size]...)
+		copy(out[idPos+size:], out[idPos:pos])
+		copy(out[idPos:], scratch)
+		*scratchP = scratch
+		d.scratchPool.Put(scratchP)
+	}
+
+	return out
+}
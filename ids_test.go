@@ -0,0 +1,202 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+func TestIDPolicy(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label      string
+		policy     IDPolicy
+		input      string
+		output     string
+		generated  bool
+		configure  func(d *Decoder)
+		streamPath string
+	}
+
+	cases := []testCase{
+		{
+			label:  "keep",
+			policy: KeepIDs,
+			input:  `{"a":1}`,
+			output: `{"a":1}`,
+		},
+		{
+			label:     "generate",
+			policy:    GenerateIDs,
+			input:     `{"a":1,"b":{"c":2}}`,
+			output:    `{"a":1,"b":{"c":2}}`,
+			generated: true,
+		},
+		{
+			label:     "generate for empty object",
+			policy:    GenerateIDs,
+			input:     `{}`,
+			output:    `{}`,
+			generated: true,
+		},
+		{
+			label:  "existing _id kept in place",
+			policy: GenerateIDs,
+			input:  `{"a":1,"_id":2,"b":3}`,
+			output: `{"a":1,"_id":2,"b":3}`,
+		},
+		{
+			label:  "existing _id moved to front",
+			policy: GenerateIDsFirst,
+			input:  `{"a":1,"b":[1,2],"_id":{"x":"y"},"c":3}`,
+			output: `{"_id":{"x":"y"},"a":1,"b":[1,2],"c":3}`,
+		},
+		{
+			label:  "existing _id already first",
+			policy: GenerateIDsFirst,
+			input:  `{"_id":"x","a":1}`,
+			output: `{"_id":"x","a":1}`,
+		},
+		{
+			label:     "nested _id doesn't count",
+			policy:    GenerateIDs,
+			input:     `{"a":{"_id":1}}`,
+			output:    `{"a":{"_id":1}}`,
+			generated: true,
+		},
+		{
+			label:     "_id projected out",
+			policy:    GenerateIDs,
+			input:     `{"_id":1,"a":2}`,
+			output:    `{"a":2}`,
+			generated: true,
+			configure: func(d *Decoder) { _ = d.Projection(ExcludePaths, "_id") },
+		},
+		{
+			label:     "last duplicate _id moved to front",
+			policy:    GenerateIDsFirst,
+			input:     `{"_id":1,"a":2,"_id":3}`,
+			output:    `{"_id":3,"a":2}`,
+			configure: func(d *Decoder) { d.DuplicateKeys(LastKeyWins) },
+		},
+		{
+			label:     "renamed _id",
+			policy:    GenerateIDsFirst,
+			input:     `{"a":1,"id":2}`,
+			output:    `{"_id":2,"a":1}`,
+			configure: func(d *Decoder) { _ = d.RenameKeys(map[string]string{"id": "_id"}) },
+		},
+		{
+			label:      "streamed array",
+			policy:     GenerateIDs,
+			input:      `{"data":[{"a":1}]}`,
+			output:     `{"a":1}`,
+			generated:  true,
+			streamPath: "/data",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(c.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jib.IDPolicy(c.policy)
+			if c.configure != nil {
+				c.configure(jib)
+			}
+			if c.streamPath != "" {
+				err = jib.StreamArray(c.streamPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := jib.Decode(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = bson.Raw(got).Validate()
+			if err != nil {
+				t.Fatalf("invalid BSON: %v", err)
+			}
+
+			if c.generated {
+				elems, err := bson.Raw(got).Elements()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if elems[0].Key() != "_id" || elems[0].Value().Type != bsontype.ObjectID {
+					t.Fatalf("expected ObjectID _id first, but got %s", bson.Raw(got))
+				}
+				// Drop the generated _id to compare the rest.
+				idLen := len(elems[0])
+				rest := append([]byte{}, got[0:4]...)
+				rest = append(rest, got[4+idLen:]...)
+				overwriteLength(rest, 0, len(rest))
+				got = rest
+			}
+
+			json, err := Marshal(got, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(json) != c.output {
+				t.Errorf("expected %s, but got %s", c.output, json)
+			}
+			_, err = jib.Decode(nil)
+			if err != io.EOF {
+				t.Errorf("expected io.EOF after document, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestIDPolicyUnique(t *testing.T) {
+	t.Parallel()
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(`{"a":1} {"a":2}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jib.IDPolicy(GenerateIDs)
+	first, err := jib.Decode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := jib.Decode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bson.Raw(first).Lookup("_id").Equal(bson.Raw(second).Lookup("_id")) {
+		t.Errorf("expected different generated _id values")
+	}
+
+	// DecodeValue doesn't add an _id.
+	jib, err = NewDecoder(bufio.NewReader(strings.NewReader(`{"a":1}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jib.IDPolicy(GenerateIDs)
+	_, val, err := jib.DecodeValue(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(val) != 12 {
+		t.Errorf("expected value without _id, but got %x", val)
+	}
+}
@@ -9,6 +9,7 @@ package jibby
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -18,6 +19,9 @@ import (
 )
 
 // ErrUnsupportedBOM means that a UTF-16 or UTF-32 byte order mark was found.
+//
+// Deprecated: UTF-16 and UTF-32 input is now transcoded, so this error is no
+// longer returned.
 var ErrUnsupportedBOM = errors.New("unsupported byte order mark")
 
 // Decoder reads and decodes JSON objects to BSON from a buffered input stream.
@@ -25,17 +29,54 @@ var ErrUnsupportedBOM = errors.New("unsupported byte order mark")
 // JSON array at the top-level.
 type Decoder struct {
 	arrayFinished  bool
+	arrayPath      [][]byte
 	arrayStarted   bool
+	buffer         *bufio.Reader
+	capture        *captureReader
+	ctx            context.Context
+	ctxCount       int
 	curDepth       int
+	docCount       int
+	docPos         position
+	dollarEscape   string
+	dotEscape      string
+	duplicateKeys  DuplicateKeyPolicy
 	extJSONAllowed bool
-	json           *bufio.Reader
+	framing        Framing
+	held           []byte
+	idPolicy       IDPolicy
+	invalidUTF8    UTF8Policy
+	json           inputReader
+	keyFrames      []keyFrame
+	keyRenames     map[string]string
+	lenient        bool
 	maxDepth       int
+	maxDocSize     int
+	numberNode     *numberPathNode
+	numberPaths    *numberPathNode
+	numberPolicy   NumberPolicy
+	numberType     NumberType
+	outStart       int
+	projectNode    *projectionNode
+	projection     *projectionNode
+	projectionMode ProjectionMode
+	rawKeyDepth    int
+	recordOpen     bool
+	recoverErrors  bool
 	scratchPool    *sync.Pool
+	shellMode      bool
+	singleQuoted   bool
+	skipStack      []byte
+	source         *bufio.Reader
+	specialKeys    SpecialKeyPolicy
+	started        bool
 }
 
 // NewDecoder returns a new decoder.  If a UTF-8 byte-order-mark (BOM) exists,
-// it will be stripped.  Because only UTF-8 is supported, other BOMs are error
-// and will return ErrUnsupportedBOM.  This function consumes leading white
+// it will be stripped.  UTF-16 and UTF-32 input, detected from a BOM or from
+// the pattern of null bytes at the start of JSON text, is transcoded to UTF-8
+// as it is read.  Offsets in errors for such input count bytes of the UTF-8
+// text, not of the original input.  This function consumes leading white
 // space and checks if the first character is '['.  If so, the input format is
 // expected to be a single JSON array of objects and the stream will consist of
 // the objects in the array.  Any read error (including io.EOF) during these
@@ -43,42 +84,203 @@ type Decoder struct {
 //
 // If the the bufio.Reader's size is less than 8192, it will be rebuffered.
 // This is necessary to account for lookahead for long decimals to minimize
-// copying.
+// copying.  UTF-16 and UTF-32 input is also rebuffered after transcoding.
 func NewDecoder(json *bufio.Reader) (*Decoder, error) {
-	if json.Size() < 8192 {
-		json = bufio.NewReaderSize(json, 8192)
-	}
-	err := handleBOM(json)
+	d := newDecoder(json)
+
+	err := d.start()
 	if err != nil {
-		return nil, err
+		// Before an object is read, EOF is a valid response that
+		// shouldn't be wrapped.
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, newReadError(err)
+	}
+
+	return d, nil
+}
+
+// NewDecoderFromReader returns a new decoder for an unbuffered input stream.
+// It works like NewDecoder, except that no input is read until the first
+// call to Decode or DecodeValue, so any read error is returned from that
+// call.  The decoder buffers the input itself.
+func NewDecoderFromReader(r io.Reader) *Decoder {
+	d := allocDecoder()
+	d.source = bufio.NewReaderSize(r, minBufferSize)
+	return d
+}
+
+// Reset discards any state and buffered input and makes the decoder read from
+// a new input stream, like a decoder from NewDecoderFromReader.  Options,
+// like ExtJSON or MaxDepth, are kept, except that StreamArray must be called
+// again for the new input.  The decoder's buffers are reused, so decoding
+// many small inputs with one decoder avoids most allocation.
+func (d *Decoder) Reset(r io.Reader) {
+	if d.source == nil {
+		size := d.json.r.Size()
+		if size < minBufferSize {
+			size = minBufferSize
+		}
+		d.source = bufio.NewReaderSize(r, size)
+	} else {
+		d.source.Reset(r)
 	}
 
-	d := &Decoder{
-		json:     json,
-		maxDepth: 200,
+	d.arrayFinished = false
+	d.arrayPath = nil
+	d.arrayStarted = false
+	d.curDepth = 0
+	d.docCount = 0
+	d.docPos = position{}
+	d.held = d.held[0:0]
+	d.keyFrames = d.keyFrames[0:0]
+	d.outStart = 0
+	d.rawKeyDepth = 0
+	d.recordOpen = false
+	d.singleQuoted = false
+	d.skipStack = d.skipStack[0:0]
+	d.started = false
+}
+
+// minBufferSize is the smallest buffer a Decoder reads through, which is
+// necessary to account for lookahead for long decimals to minimize copying.
+const minBufferSize = 8192
+
+// newDecoder returns a new decoder like NewDecoder, but without consuming any
+// input after the BOM, so a top-level array is a single value.
+func newDecoder(json *bufio.Reader) *Decoder {
+	d := allocDecoder()
+	d.setInput(json)
+	d.started = true
+	return d
+}
+
+// allocDecoder returns a new decoder with default options.  It has no input.
+func allocDecoder() *Decoder {
+	return &Decoder{
+		maxDepth:     200,
+		maxDocSize:   defaultMaxDocumentSize,
+		dotEscape:    defaultDotEscape,
+		dollarEscape: defaultDollarEscape,
 		scratchPool: &sync.Pool{
 			New: func() interface{} { buf := make([]byte, 0, 256); return &buf },
 		},
 	}
+}
+
+// setInput makes the decoder read from a buffered input stream.  It detects
+// the encoding of the input and strips any BOM.  The stream is read directly
+// unless it has to be transcoded or rebuffered, or the input has to be
+// captured.
+func (d *Decoder) setInput(json *bufio.Reader) {
+	var src io.Reader = json
+	enc := detectEncoding(json)
+	if enc != encodingUTF8 {
+		src = newTranscoder(json, enc)
+	}
+
+	d.json.reset(json)
+	if d.capture != nil {
+		d.capture.stopCapture()
+	}
+	size := json.Size()
+	if enc != encodingUTF8 || size < minBufferSize || d.needsCapture() {
+		if size < minBufferSize {
+			size = minBufferSize
+		}
+		d.readThrough(src, 0, size)
+		if d.needsCapture() {
+			d.capture.startCapture()
+		}
+	}
+	handleBOM(&d.json)
+}
+
+// readThrough makes the decoder read input from the given offset in the
+// stream through a captureReader and a buffer of its own.
+func (d *Decoder) readThrough(src io.Reader, offset int64, size int) {
+	d.json.count()
+	if d.capture == nil {
+		d.capture = &captureReader{}
+	}
+	d.capture.reset(src, offset)
+	if d.buffer == nil || d.buffer.Size() < size {
+		d.buffer = bufio.NewReaderSize(d.capture, size)
+	} else {
+		d.buffer.Reset(d.capture)
+	}
+	d.json.moveTo(d.buffer, offset)
+}
+
+// needsCapture reports whether the decoder needs a copy of the input for the
+// current value, which error recovery and JSONSeqFraming use to skip a
+// malformed value.
+func (d *Decoder) needsCapture() bool {
+	return d.recoverErrors || d.framing == JSONSeqFraming
+}
+
+// updateCapture starts or stops capturing the input, if needed, after an
+// option changes.
+func (d *Decoder) updateCapture() {
+	capturing := d.capture != nil && d.capture.capturing
+	if !d.started || d.needsCapture() == capturing {
+		return
+	}
+	if capturing {
+		d.capture.stopCapture()
+		return
+	}
+
+	// Input that was already buffered has to be read again to capture it.
+	if d.json.r != d.buffer {
+		d.readThrough(d.json.r, d.offset(), d.json.r.Size())
+	} else {
+		d.json.count()
+		offset := d.offset()
+		buffered, _ := d.json.Peek(d.json.Buffered())
+		d.capture.unread(buffered)
+		d.buffer.Reset(d.capture)
+		d.json.moveTo(d.buffer, offset)
+	}
+	d.capture.startCapture()
+}
+
+// start consumes leading white space and checks if the first character is
+// '[' for a top-level array, like detectArray.  For input from
+// NewDecoderFromReader or Reset, it first sets up the input.  Any error is
+// returned without wrapping.
+func (d *Decoder) start() error {
+	d.started = true
+	if d.source != nil {
+		d.setInput(d.source)
+	}
+
+	return d.detectArray()
+}
+
+// detectArray consumes white space and checks if the next character is '['
+// for a top-level array, if the framing allows one.  Any error is returned
+// without wrapping.
+func (d *Decoder) detectArray() error {
+	// With other framings, leading white space may be significant, like an
+	// empty first line of NDJSON.
+	if !d.framing.allowsArray() {
+		return nil
+	}
 
 	ch, err := d.readAfterWS()
 	if err != nil {
-		// Before an object is read, EOF is a valid response that
-		// shouldn't be wrapped.
-		if err == io.EOF {
-			return nil, err
-		}
-		return nil, newReadError(err)
+		return err
 	}
 
-	switch ch {
-	case '[':
+	if ch == '[' {
 		d.arrayStarted = true
-	default:
+	} else {
 		_ = d.json.UnreadByte()
 	}
 
-	return d, nil
+	return nil
 }
 
 // ExtJSON toggles whether extended JSON is interpreted by the decoder.
@@ -94,66 +296,380 @@ func (d *Decoder) MaxDepth(n int) {
 	d.maxDepth = n
 }
 
+// MaxDocumentSize sets the maximum size in bytes of a BSON document converted
+// from a top-level value.  Conversion stops with a DocumentSizeError as soon as
+// the output passes the limit.  The default is 16 MiB, the largest document
+// MongoDB accepts.  A size less than one means no limit.
+func (d *Decoder) MaxDocumentSize(n int) {
+	d.maxDocSize = n
+}
+
+// Recover toggles error recovery.  When enabled, if Decode returns a
+// ParseError or a DocumentSizeError, it first skips the rest of the malformed
+// or oversized top-level value so that the next call to Decode continues with
+// the value after it.  The text that was skipped is available in the
+// ParseError's Skipped field.
+//
+// If the input is a top-level array, the rest of the value up to the next
+// element of the array is skipped.  Otherwise, the input is assumed to be
+// newline-delimited and the rest of the line on which the value started is
+// skipped.  Other errors, like read errors, are not recoverable.
+//
+// To report the skipped text, the decoder keeps a copy of the input for
+// the current value, so recovery is off by default.
+func (d *Decoder) Recover(b bool) {
+	d.recoverErrors = b
+	d.updateCapture()
+}
+
 // Decode converts a single JSON object from the input stream into BSON object.
 // The function takes an output buffer as an argument.  If the buffer is not
 // large enough, a new buffer will be allocated when needed.  The final buffer
 // is returned, just like with `append`.  The function returns io.EOF if no
 // objects remain in the stream.
 func (d *Decoder) Decode(buf []byte) ([]byte, error) {
+	// A document held back by DecodeBatch comes first.
+	if len(d.held) > 0 {
+		buf = append(buf, d.held...)
+		d.held = d.held[0:0]
+		return buf, nil
+	}
+
+	ch, err := d.startTopValue()
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err = d.decodeTopObject(buf, ch)
+	if err != nil {
+		return nil, d.resync(err)
+	}
+
+	return buf, nil
+}
+
+// DecodeValue converts a single JSON value of any type from the input stream
+// into a BSON value.  It returns the BSON type of the value and appends the
+// value bytes to the output buffer, just like with `append`.  If extended JSON
+// is enabled, an object that is extended JSON is converted to the
+// corresponding BSON type.  The function returns io.EOF if no values remain in
+// the stream.
+//
+// Values in the stream are separated like objects for Decode: if the stream
+// is a top-level array, each call returns the next element of the array.  A
+// document held back by DecodeBatch is returned first, as an embedded
+// document.
+func (d *Decoder) DecodeValue(buf []byte) (byte, []byte, error) {
+	if len(d.held) > 0 {
+		buf = append(buf, d.held...)
+		d.held = d.held[0:0]
+		return bsonDocument, buf, nil
+	}
+
+	_, err := d.startTopValue()
+	if err != nil {
+		return 0, nil, err
+	}
+	_ = d.json.UnreadByte()
+
+	// Convert with a placeholder type byte, then remove it.
+	typeBytePos := len(buf)
+	buf = append(buf, emptyType)
+	d.outStart = typeBytePos + 1
+	d.numberNode = d.numberPaths
+	d.projectNode = d.projection
+	buf, err = d.convertValue(buf, typeBytePos)
+	if err == nil {
+		err = d.checkDocumentSize(buf)
+	}
+	if err == nil {
+		err = d.finishTopValue()
+	}
+	if err != nil {
+		return 0, nil, d.resync(err)
+	}
+	bsonType := buf[typeBytePos]
+	copy(buf[typeBytePos:], buf[typeBytePos+1:])
+
+	return bsonType, buf[0 : len(buf)-1], nil
+}
+
+// startTopValue reads the first character of the next top-level value and
+// records the start of the value.  It returns io.EOF if no values remain in
+// the stream.
+func (d *Decoder) startTopValue() (byte, error) {
 	if d.arrayFinished {
-		return nil, io.EOF
+		return 0, io.EOF
 	}
 
-	ch, err := d.readAfterWS()
+	if !d.started {
+		err := d.start()
+		if err != nil {
+			if err == io.EOF {
+				return 0, err
+			}
+			return 0, newReadError(err)
+		}
+	}
+
+	if d.framing == ArrayFraming && !d.arrayStarted && d.docCount == 0 {
+		return 0, d.notArrayError()
+	}
+
+	if d.arrayPath != nil {
+		err := d.findArray()
+		d.arrayPath = nil
+		if err != nil {
+			d.arrayFinished = true
+			return 0, err
+		}
+	}
+
+	var ch byte
+	var err error
+	sawRS := true
+	switch d.framing {
+	case NDJSONFraming:
+		ch, err = d.readAfterLineSpace()
+	case JSONSeqFraming:
+		ch, sawRS, err = d.readRecordStart()
+	default:
+		ch, err = d.readAfterWS()
+	}
 	if err != nil {
 		// Before an object is read, EOF is a valid response that
 		// shouldn't be wrapped.
 		if err == io.EOF {
-			return nil, err
+			return 0, err
 		}
-		return nil, newReadError(err)
+		return 0, newReadError(err)
+	}
+
+	// This case will only occur for an empty top-level array: `[]`.
+	// Otherwise, the closing array bracket is read after an object.
+	if ch == ']' && d.arrayStarted {
+		d.arrayFinished = true
+		if d.framing == ArrayFraming {
+			err = d.readInputEnd()
+			if err != nil {
+				return 0, err
+			}
+		}
+		return 0, io.EOF
 	}
 
+	d.docCount++
+	d.startDocument(ch)
+
+	if d.framing == NDJSONFraming && ch == '\n' {
+		return 0, d.parseError([]byte{ch}, "empty line in NDJSON")
+	}
+	if !sawRS {
+		return 0, d.resync(d.parseError([]byte{ch}, "expecting record separator"))
+	}
+
+	return ch, nil
+}
+
+// decodeTopObject converts a top-level object starting with a character that
+// has already been read.  It also consumes the input after the object that
+// the framing requires, like the value-separator or end of a top-level array.
+func (d *Decoder) decodeTopObject(buf []byte, ch byte) ([]byte, error) {
 	switch ch {
 	case '{':
 		_ = d.json.UnreadByte()
-	case ']':
-		// This case will only occur for an empty top-level array: `[]`.
-		// Otherwise, the closing array bracket is read after an object.
-		if d.arrayStarted {
-			d.arrayFinished = true
-			return nil, io.EOF
-		}
-		return nil, d.parseError([]byte{ch}, "Decode only supports object decoding")
 	default:
 		return nil, d.parseError([]byte{ch}, "Decode only supports object decoding")
 	}
 
-	buf, err = d.convertValue(buf, topContainer)
+	d.outStart = len(buf)
+	d.numberNode = d.numberPaths
+	d.projectNode = d.projection
+	buf, err := d.convertValue(buf, topContainer)
+	if err == nil {
+		err = d.checkDocumentSize(buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.finishTopValue()
 	if err != nil {
 		return nil, err
 	}
 
-	// If in comma mode, consume comma or ']', otherwise, put the
-	// the character back to be
-	// After ']', terminate stream?
-	if d.arrayStarted {
-		ch, err := d.readAfterWS()
+	return buf, nil
+}
+
+// readArraySeparator consumes the value-separator or end of array after a
+// value in a top-level array.  Otherwise, it does nothing.
+func (d *Decoder) readArraySeparator() error {
+	if !d.arrayStarted {
+		return nil
+	}
+
+	ch, err := d.readAfterWS()
+	if err != nil {
+		return newReadError(err)
+	}
+
+	switch ch {
+	case ',':
+		// nothing
+	case ']':
+		d.arrayFinished = true
+	default:
+		return d.parseError([]byte{ch}, "expecting value-separator or end of array")
+	}
+
+	return nil
+}
+
+// resync implements error recovery for Decode.  If recovery is enabled and
+// the error is a ParseError, it skips the rest of the current top-level value
+// and records the skipped text in the error.  A JSON text sequence always
+// skips to the next record, but only records the text if recovery is
+// enabled.  It returns the error given unless skipping fails.
+func (d *Decoder) resync(err error) error {
+	var pe *ParseError
+	var se *DocumentSizeError
+	if !(errors.As(err, &pe) || errors.As(err, &se)) {
+		return err
+	}
+	if !d.recoverErrors && d.framing != JSONSeqFraming {
+		return err
+	}
+
+	start := d.docPos.offset
+	var end int64
+	var skipErr error
+	switch {
+	case d.framing == JSONSeqFraming:
+		end, skipErr = d.skipRecord(start)
+	case d.arrayStarted:
+		end, skipErr = d.skipArrayElement(start)
+	default:
+		end, skipErr = d.skipLine(start)
+	}
+	if skipErr != nil {
+		return skipErr
+	}
+
+	if pe != nil && d.recoverErrors {
+		skipped := d.capture.captured(start, end)
+		pe.Skipped = make([]byte, len(skipped))
+		copy(pe.Skipped, skipped)
+	}
+
+	return err
+}
+
+// skipLine positions the input after the end of the line that contains an
+// offset and returns the offset of the end of the line, excluding any
+// carriage return.  If the end of the line was already consumed, the input is
+// rewound.
+func (d *Decoder) skipLine(start int64) (int64, error) {
+	end := d.offset()
+	consumed := d.capture.captured(start, end)
+	if i := bytes.IndexByte(consumed, '\n'); i >= 0 {
+		end = start + int64(i)
+		d.rewind(end + 1)
+	} else {
+		for {
+			_, err := d.json.ReadSlice('\n')
+			if err == nil {
+				end = d.offset() - 1
+				break
+			}
+			if err == io.EOF {
+				end = d.offset()
+				break
+			}
+			if err != bufio.ErrBufferFull {
+				return 0, newReadError(err)
+			}
+		}
+	}
+
+	if end > start && d.capture.captured(end-1, end)[0] == '\r' {
+		end--
+	}
+	return end, nil
+}
+
+// skipArrayElement rewinds the input to an offset and skips over a value in a
+// top-level array, including the following value-separator or end of array.
+// It returns the offset of the end of the value.  The value may be
+// malformed, so it only tracks strings and nesting.  A closing bracket or
+// brace also closes any unclosed containers inside the one it matches, and a
+// string also ends at a newline, because JSON strings can't contain one.  If
+// the input ends first, the array is treated as finished.
+func (d *Decoder) skipArrayElement(start int64) (int64, error) {
+	d.rewind(start)
+
+	var open []byte
+	var inString, escaped bool
+	for {
+		ch, err := d.json.ReadByte()
 		if err != nil {
-			return nil, newReadError(err)
+			if err == io.EOF {
+				d.arrayFinished = true
+				return d.offset(), nil
+			}
+			return 0, newReadError(err)
+		}
+
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"', ch == '\n':
+				inString = false
+			}
+			continue
 		}
 
 		switch ch {
+		case '"':
+			inString = true
+		case '{', '[':
+			open = append(open, ch)
+		case '}', ']':
+			if len(open) == 0 {
+				if ch == ']' {
+					d.arrayFinished = true
+					return d.offset() - 1, nil
+				}
+				continue
+			}
+			// Close the innermost matching container, if any, as well as
+			// any unclosed containers inside it.
+			opener := byte('{')
+			if ch == ']' {
+				opener = '['
+			}
+			if i := bytes.LastIndexByte(open, opener); i >= 0 {
+				open = open[0:i]
+			}
 		case ',':
-			// nothing
-		case ']':
-			d.arrayFinished = true
-		default:
-			return nil, d.parseError([]byte{ch}, "expecting value-separator or end of array")
+			if len(open) == 0 {
+				return d.offset() - 1, nil
+			}
 		}
 	}
+}
 
-	return buf, nil
+// rewind moves the input stream back to an earlier, captured offset in the
+// current value.
+func (d *Decoder) rewind(offset int64) {
+	consumed := d.capture.captured(offset, d.offset())
+	kept := d.capture.captured(d.docPos.offset, offset)
+	d.json.uncount(consumed, d.docPos, kept)
+	d.capture.unread(d.capture.captured(offset, d.capture.offset))
+	d.buffer.Reset(d.capture)
+	d.json.moveTo(d.buffer, offset)
 }
 
 // readAfterWS discards JSON white space and returns the next character.
@@ -170,6 +686,17 @@ func (d *Decoder) readAfterWS() (byte, error) {
 		}
 		switch ch {
 		case ' ', '\t', '\n', '\r':
+		case '/':
+			if !d.lenient {
+				return ch, nil
+			}
+			ok, err := d.skipComment()
+			if err != nil {
+				return 0, err
+			}
+			if !ok {
+				return ch, nil
+			}
 		default:
 			return ch, nil
 		}
@@ -336,12 +863,23 @@ LOOP:
 		case ' ', '\t', '\n', '\r', ',', ']', '}':
 			terminated = true
 			break LOOP
+		case '/':
+			// A comment may follow a number in lenient mode.
+			if d.lenient {
+				terminated = true
+				break LOOP
+			}
 		case '_':
 			_, _ = d.json.Discard(i)
 			return nil, false, d.parseError(nil, "invalid character in number")
 		}
 	}
 
+	// A top-level number may end at the end of input.
+	if !terminated && err == io.EOF && d.curDepth == 0 {
+		terminated = true
+	}
+
 	if !terminated {
 		if len(buf) < doublePeekWidth {
 			return nil, false, newReadError(io.ErrUnexpectedEOF)
@@ -419,6 +957,12 @@ LOOP:
 		case ' ', '\t', '\n', '\r', ',', ']', '}':
 			terminated = true
 			break LOOP
+		case ')':
+			// Ends an argument to a shell constructor.
+			if d.shellMode {
+				terminated = true
+				break LOOP
+			}
 		}
 	}
 
@@ -490,8 +1034,48 @@ func (d *Decoder) readInt64() (int64, error) {
 
 const parseErrorContextLength = 10
 
-// parseError returns an error with a message and some context for where it occurs.
+// checkDocumentSize returns a DocumentSizeError if the output for the current
+// top-level value is over the maximum size.
+func (d *Decoder) checkDocumentSize(out []byte) error {
+	if d.maxDocSize > 0 && len(out)-d.outStart > d.maxDocSize {
+		return &DocumentSizeError{Document: d.docCount - 1, Offset: d.docPos.offset, Limit: d.maxDocSize}
+	}
+	return nil
+}
+
+// offset returns the offset in the input stream of the next byte to be read.
+func (d *Decoder) offset() int64 {
+	return d.json.offset()
+}
+
+// startDocument records the start of a top-level value at its first
+// character, which was just consumed.
+func (d *Decoder) startDocument(ch byte) {
+	d.docPos = d.json.before([]byte{ch})
+	d.json.mark = d.docPos.offset
+	if d.capture != nil && d.capture.capturing {
+		d.capture.trimCapture(d.docPos.offset)
+	}
+}
+
+// parseError returns an error with a message and some context for where it
+// occurs.  Any context given must be the bytes that were most recently
+// consumed from the input stream.
 func (d *Decoder) parseError(startingAt []byte, msg string) error {
+	return d.parseErrorAt(d.json.before(startingAt), startingAt, msg)
+}
+
+// parseErrorAt works like parseError, but for context that starts at a given
+// position in the input stream.
+func (d *Decoder) parseErrorAt(pos position, startingAt []byte, msg string) error {
+	line, column := pos.lineColumn()
+	pe := &ParseError{
+		Offset:   pos.offset,
+		Line:     line,
+		Column:   column,
+		Document: d.docCount - 1,
+	}
+
 	if len(startingAt) < parseErrorContextLength {
 		after, err := d.json.Peek(parseErrorContextLength - len(startingAt))
 		if len(after) > 0 {
@@ -503,9 +1087,11 @@ func (d *Decoder) parseError(startingAt []byte, msg string) error {
 		}
 	}
 	if len(startingAt) > 0 {
-		return &ParseError{msg: fmt.Sprintf("parse error at `%s`: %s", startingAt, msg)}
+		pe.msg = fmt.Sprintf("parse error at `%s`: %s", startingAt, msg)
+	} else {
+		pe.msg = fmt.Sprintf("parse error: %s", msg)
 	}
-	return &ParseError{fmt.Sprintf("parse error: %s", msg)}
+	return pe
 }
 
 // copyPeek returns a copy of a Peek into the start of the buffer.
@@ -555,6 +1141,27 @@ func UnmarshalExtJSON(in []byte, out []byte) ([]byte, error) {
 	return jib.Decode(out)
 }
 
+// UnmarshalValue converts a single JSON value of any type to a BSON value.  It
+// returns the BSON type of the value and appends the value bytes to the output
+// buffer, just like with `append`.  Unlike with `Unmarshal`, a top-level array
+// is converted as a single value.  The function returns io.EOF if the input is
+// empty.
+func UnmarshalValue(in []byte, out []byte) (byte, []byte, error) {
+	jsonReader := bufio.NewReaderSize(bytes.NewReader([]byte(in)), 8192)
+	jib := newDecoder(jsonReader)
+	return jib.DecodeValue(out)
+}
+
+// UnmarshalValueExtJSON converts a single Extended JSON value to a BSON value.
+// A top-level object that is Extended JSON, like `{"$date": ...}`, is converted
+// to the corresponding BSON type.  It otherwise works like `UnmarshalValue`.
+func UnmarshalValueExtJSON(in []byte, out []byte) (byte, []byte, error) {
+	jsonReader := bufio.NewReaderSize(bytes.NewReader([]byte(in)), 8192)
+	jib := newDecoder(jsonReader)
+	jib.ExtJSON(true)
+	return jib.DecodeValue(out)
+}
+
 // overwriteTypeByte is a helper for writing a type byte that
 // no-ops for a top-level container.
 func overwriteTypeByte(out []byte, pos int, bsonType byte) {
@@ -571,38 +1178,17 @@ func overwriteLength(out []byte, pos int, n int) {
 	binary.LittleEndian.PutUint32(out[pos:pos+4], uint32(n))
 }
 
-// handleBOM will detect/discard/error based on the BOM. Inability to peek a BOM is a
-// no-op, not an error so it can be handled by the normal parser.  Only UTF-8
-// BOM is supported; others will error.
-func handleBOM(r *bufio.Reader) error {
-	// Peek 2 byte BOMs
-	preamble, err := r.Peek(2)
+// handleBOM discards a UTF-8 BOM.  Inability to peek a BOM is a no-op, not an
+// error, so it can be handled by the normal parser.  Input in other encodings
+// has been transcoded to UTF-8, so its BOM is also discarded here.
+func handleBOM(r *inputReader) {
+	preamble, err := r.Peek(3)
 	if err != nil {
-		return nil
-	}
-	if bytes.Equal(preamble, utf16BEBOM) || bytes.Equal(preamble, utf16LEBOM) {
-		return ErrUnsupportedBOM
-	}
-
-	// Peek 3 byte BOM; UTF-8 is supported, so discard them if found.
-	preamble, err = r.Peek(3)
-	if err != nil {
-		return nil
+		return
 	}
 	if bytes.Equal(preamble, utf8BOM) {
 		_, _ = r.Discard(3)
 	}
-
-	// Peek 4 byte BOMs
-	preamble, err = r.Peek(4)
-	if err != nil {
-		return nil
-	}
-	if bytes.Equal(preamble, utf32BEBOM) || bytes.Equal(preamble, utf32LEBOM) {
-		return ErrUnsupportedBOM
-	}
-
-	return nil
 }
 
 // newReadError is used when we expect to be able to read and fail.  If the
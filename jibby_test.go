@@ -10,11 +10,18 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 )
 
 const JSONTestSuite = "testdata/JSONTestSuite/test_parsing"
@@ -53,13 +60,14 @@ func TestJibbyTestSuite_Passing(t *testing.T) {
 // For implementation defined behavior: these errors are allowed as we don't
 // support the related features.
 var allowedErrors = []string{
-	ErrUnsupportedBOM.Error(),
 	"value out of range", // large ints/floats
 }
 
 // We assume valid UTF-8; these files test handling invalid strings,
-// so we skip these files.
+// so we skip these files.  UTF-16 files can't be objectified, so they are
+// tested separately.
 var unsupportedTests = map[string]bool{
+	"i_string_UTF-16LE_with_BOM.json":              true,
 	"i_string_UTF-8_invalid_sequence.json":         true,
 	"i_string_UTF8_surrogate_U+D800.json":          true,
 	"i_string_invalid_utf-8.json":                  true,
@@ -403,3 +411,527 @@ func testDepthLimit(t *testing.T, input string) {
 	}
 
 }
+
+// TestMaxDocumentSize checks the limit on the size of converted documents.
+func TestMaxDocumentSize(t *testing.T) {
+	t.Parallel()
+
+	// `{"a":"xxxx"}` converts to 17 bytes.
+	input := "{\"a\":1}\n  {\"a\":\"xxxx\"}\n{\"a\":[\"" + strings.Repeat("x", 100000) + "\"]}\n{\"a\":2}"
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jib.MaxDocumentSize(17)
+	jib.Recover(true)
+
+	_, err = jib.Decode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jib.Decode(nil)
+	if err != nil {
+		t.Fatalf("expected no error at limit, but got %v", err)
+	}
+
+	// A document over the limit is rejected.
+	_, err = jib.Decode(nil)
+	var se *DocumentSizeError
+	if !errors.As(err, &se) {
+		t.Fatalf("expected DocumentSizeError, but got %v", err)
+	}
+	expect := DocumentSizeError{Document: 2, Offset: 23, Limit: 17}
+	if *se != expect {
+		t.Errorf("expected %+v, but got %+v", expect, *se)
+	}
+
+	// With recovery, decoding continues after the document.
+	_, err = jib.Decode(nil)
+	if err != nil {
+		t.Fatalf("expected no error after recovery, but got %v", err)
+	}
+
+	// The limit applies to values and the default is 16 MiB.
+	long := `"` + strings.Repeat("x", 16*1024*1024) + `"`
+	_, _, err = UnmarshalValue([]byte(long), nil)
+	if !errors.As(err, &se) {
+		t.Fatalf("expected DocumentSizeError for value, but got %v", err)
+	}
+	jib, err = NewDecoder(bufio.NewReader(strings.NewReader(long)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jib.MaxDocumentSize(0)
+	_, _, err = jib.DecodeValue(nil)
+	if err != nil {
+		t.Fatalf("expected no error without limit, but got %v", err)
+	}
+}
+
+// TestRecover checks that a decoder with error recovery enabled skips
+// malformed values and reports the skipped text.
+func TestRecover(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label   string
+		input   string
+		output  []string
+		skipped []string
+		errStr  string
+	}
+
+	cases := []testCase{
+		{
+			label:   "newline-delimited",
+			input:   "{\"a\":1}\n{\"a\":tru}\n{\"a\":3}\n",
+			output:  []string{`{"a":1}`, `{"a":3}`},
+			skipped: []string{`{"a":tru}`},
+			errStr:  io.EOF.Error(),
+		},
+		{
+			label:   "newline-delimited, error on next line",
+			input:   "{\"a\":1\r\n{\"a\":2}\r\n42 43\n{\"a\":3}",
+			output:  []string{`{"a":2}`, `{"a":3}`},
+			skipped: []string{`{"a":1`, `42 43`},
+			errStr:  io.EOF.Error(),
+		},
+		{
+			label:   "newline-delimited, unterminated",
+			input:   "{\"a\":1}\n{\"a\":[1,2",
+			output:  []string{`{"a":1}`},
+			skipped: []string{},
+			errStr:  "unexpected EOF",
+		},
+		{
+			label:   "newline-delimited, last line",
+			input:   "{\"a\":1}\n{\"a\":1 2}",
+			output:  []string{`{"a":1}`},
+			skipped: []string{`{"a":1 2}`},
+			errStr:  io.EOF.Error(),
+		},
+		{
+			label:   "array",
+			input:   `[{"a":1}, {"a":{"b":[1,2,}, "c":"],}"}, {"a":3}, 42, {"a":5}]`,
+			output:  []string{`{"a":1}`, `{"a":3}`, `{"a":5}`},
+			skipped: []string{`{"a":{"b":[1,2,}, "c":"],}"}`, `42`},
+			errStr:  io.EOF.Error(),
+		},
+		{
+			label:   "array, bad separator",
+			input:   `[{"a":1} {"a":2}, {"a":3}]`,
+			output:  []string{`{"a":3}`},
+			skipped: []string{`{"a":1} {"a":2}`},
+			errStr:  io.EOF.Error(),
+		},
+		{
+			label:   "array, last element",
+			input:   `[{"a":1}, {"a":"\x"}]`,
+			output:  []string{`{"a":1}`},
+			skipped: []string{`{"a":"\x"}`},
+			errStr:  io.EOF.Error(),
+		},
+		{
+			label:   "array, unterminated",
+			input:   `[{"a":1}, {"a":"`,
+			output:  []string{`{"a":1}`},
+			skipped: []string{},
+			errStr:  "unexpected EOF",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(c.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jib.Recover(true)
+
+			output := make([]string, 0)
+			skipped := make([]string, 0)
+			for {
+				var buf []byte
+				buf, err = jib.Decode(nil)
+				if err == nil {
+					json, err := Marshal(buf, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					output = append(output, string(json))
+					continue
+				}
+				var pe *ParseError
+				if !errors.As(err, &pe) {
+					break
+				}
+				skipped = append(skipped, string(pe.Skipped))
+			}
+
+			if !strings.Contains(err.Error(), c.errStr) {
+				t.Errorf("expected error with '%s', but got %v", c.errStr, err)
+			}
+			if !reflect.DeepEqual(output, c.output) {
+				t.Errorf("expected output %q, but got %q", c.output, output)
+			}
+			if !reflect.DeepEqual(skipped, c.skipped) {
+				t.Errorf("expected skipped %q, but got %q", c.skipped, skipped)
+			}
+		})
+	}
+}
+
+// TestRecoverLongStream checks recovery when it is enabled after decoding has
+// started and when malformed values are larger than the input buffer.
+func TestRecoverLongStream(t *testing.T) {
+	t.Parallel()
+
+	long := `{"a":"` + strings.Repeat("x", 20000) + `",}`
+	input := strings.Repeat("{\"a\":1}\n", 1000) + long + "\n" + strings.Repeat("{\"a\":1}\n", 1000) + "{\"a\":x}\n{\"a\":2}"
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jib.Decode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jib.Recover(true)
+
+	var count int
+	var errs []*ParseError
+	for {
+		_, err = jib.Decode(nil)
+		if err == io.EOF {
+			break
+		}
+		var pe *ParseError
+		if errors.As(err, &pe) {
+			errs = append(errs, pe)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+
+	if count != 2000 {
+		t.Errorf("expected 2000 documents, but got %d", count)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, but got %d", len(errs))
+	}
+	if string(errs[0].Skipped) != long || errs[0].Line != 1001 || errs[0].Document != 1000 {
+		t.Errorf("unexpected first error at line %d, document %d: %v", errs[0].Line, errs[0].Document, errs[0])
+	}
+	if string(errs[1].Skipped) != `{"a":x}` || errs[1].Line != 2002 || errs[1].Column != 6 {
+		t.Errorf("unexpected second error at line %d, column %d: %v", errs[1].Line, errs[1].Column, errs[1])
+	}
+}
+
+// TestUnmarshalValue checks conversion of top-level values of any type.
+func TestUnmarshalValue(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label    string
+		input    string
+		extJSON  bool
+		bsonType byte
+		output   string
+		errStr   string
+	}
+
+	cases := []testCase{
+		{label: "object", input: `{"a":1}`, bsonType: bsonDocument, output: "0c0000001061000100000000"},
+		{label: "array", input: ` [1, "a"] `, bsonType: bsonArray, output: "150000001030000100000002310002000000610000"},
+		{label: "string", input: `"abc"`, bsonType: bsonString, output: "0400000061626300"},
+		{label: "int32", input: `42`, bsonType: bsonInt32, output: "2a000000"},
+		{label: "int64", input: "-4294967296\n", bsonType: bsonInt64, output: "00000000ffffffff"},
+		{label: "double", input: `1.5`, bsonType: bsonDouble, output: "000000000000f83f"},
+		{label: "true", input: `true`, bsonType: bsonBoolean, output: "01"},
+		{label: "false", input: `false`, bsonType: bsonBoolean, output: "00"},
+		{label: "null", input: `null`, bsonType: bsonNull, output: ""},
+		{label: "$date not extended JSON", input: `{"$date":"1970-01-01T00:00:00Z"}`, bsonType: bsonDocument, output: "250000000224646174650015000000313937302d30312d30315430303a30303a30305a0000"},
+		{label: "$date", input: `{"$date":"1970-01-01T00:00:01Z"}`, extJSON: true, bsonType: bsonDateTime, output: "e803000000000000"},
+		{label: "$numberLong", input: `{"$numberLong":"1"}`, extJSON: true, bsonType: bsonInt64, output: "0100000000000000"},
+		{label: "$oid", input: `{"$oid":"56e1fc72e0c917e9c4714161"}`, extJSON: true, bsonType: bsonObjectID, output: "56e1fc72e0c917e9c4714161"},
+		{label: "$minKey", input: `{"$minKey":1}`, extJSON: true, bsonType: bsonMinKey, output: ""},
+		{label: "extended JSON in array", input: `[{"$numberInt":"1"}]`, extJSON: true, bsonType: bsonArray, output: "0c0000001030000100000000"},
+		{label: "empty", input: ` `, errStr: io.EOF.Error()},
+		{label: "truncated number", input: `-`, errStr: "number not found"},
+		{label: "invalid", input: `nul`, errStr: "unexpected EOF"},
+		{label: "invalid extended JSON", input: `{"$numberInt":"a"}`, extJSON: true, errStr: "int conversion"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+			var bsonType byte
+			var buf []byte
+			var err error
+			if c.extJSON {
+				bsonType, buf, err = UnmarshalValueExtJSON([]byte(c.input), nil)
+			} else {
+				bsonType, buf, err = UnmarshalValue([]byte(c.input), nil)
+			}
+			if c.errStr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errStr) {
+					t.Errorf("expected error with '%s', but got %v", c.errStr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if bsonType != c.bsonType {
+				t.Errorf("expected type 0x%02x, but got 0x%02x", c.bsonType, bsonType)
+			}
+			if hex.EncodeToString(buf) != c.output {
+				t.Errorf("UnmarshalValue doesn't match expected:\nGot:    %s\nExpect: %s", hex.EncodeToString(buf), c.output)
+			}
+		})
+	}
+}
+
+// TestDecodeValue checks streaming values of any type.
+func TestDecodeValue(t *testing.T) {
+	t.Parallel()
+
+	for _, input := range []string{`1 "a" {"b":true} [null]`, `[1, "a", {"b":true}, [null]]`} {
+		jib, err := NewDecoder(bufio.NewReader(strings.NewReader(input)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 0, 256)
+		var types []byte
+		for {
+			var bsonType byte
+			var out []byte
+			bsonType, out, err = jib.DecodeValue(buf)
+			if err != nil {
+				break
+			}
+			buf = out
+			types = append(types, bsonType)
+		}
+		if err != io.EOF {
+			t.Errorf("expected io.EOF, but got %v", err)
+		}
+		expect := []byte{bsonInt32, bsonString, bsonDocument, bsonArray}
+		if !bytes.Equal(types, expect) {
+			t.Errorf("expected types %v, but got %v", expect, types)
+		}
+		value := "01000000" + "0200000061" + "00" + "0900000008620001" + "00" + "080000000a300000"
+		if hex.EncodeToString(buf) != value {
+			t.Errorf("DecodeValue doesn't match expected:\nGot:    %s\nExpect: %s", hex.EncodeToString(buf), value)
+		}
+	}
+}
+
+func TestNewDecoderFromReader(t *testing.T) {
+	t.Parallel()
+
+	// Nothing is read until the first Decode.
+	r := &countingReader{r: strings.NewReader(` [{"a":1},{"a":2}]`)}
+	jib := NewDecoderFromReader(r)
+	if r.n != 0 {
+		t.Fatalf("expected no reads before Decode, but got %d", r.n)
+	}
+	output := decodeStream(t, jib)
+	expect := []string{`{"a":1}`, `{"a":2}`}
+	if !reflect.DeepEqual(output, expect) {
+		t.Errorf("expected %q, but got %q", expect, output)
+	}
+
+	// UTF-16 input is transcoded.
+	jib = NewDecoderFromReader(bytes.NewReader([]byte{'{', 0, '}', 0}))
+	output = decodeStream(t, jib)
+	if !reflect.DeepEqual(output, []string{`{}`}) {
+		t.Errorf("expected %q, but got %q", []string{`{}`}, output)
+	}
+
+	// Read errors come from Decode.
+	jib = NewDecoderFromReader(errorReader{})
+	_, err := jib.Decode(nil)
+	if !errors.Is(err, errTestRead) {
+		t.Errorf("expected read error, but got %v", err)
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	t.Parallel()
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(`[{"a":1},`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jib.ExtJSON(true)
+	err = jib.StreamArray("/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// State from the first input, including a pending StreamArray, is
+	// discarded, but options are kept.
+	jib.Reset(strings.NewReader(`{"a":{"$numberLong":"1"}}` + "\n{\"b\":tru}"))
+	buf, err := jib.Decode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bson.Raw(buf).Lookup("a").Type != bsontype.Int64 {
+		t.Errorf("expected $numberLong to convert to int64, but got %s", bson.Raw(buf))
+	}
+	_, err = jib.Decode(nil)
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected *ParseError, but got %v", err)
+	}
+	if pe.Line != 2 || pe.Document != 1 {
+		t.Errorf("expected error on line 2 of document 1, but got line %d of document %d", pe.Line, pe.Document)
+	}
+
+	jib.Reset(strings.NewReader(`[]`))
+	_, err = jib.Decode(nil)
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, but got %v", err)
+	}
+}
+
+func TestDecoderResetAllocs(t *testing.T) {
+	input := []byte(`{"a":1,"b":"two","c":[true,null,3.5],"d":{"e":"f"}}`)
+	r := bytes.NewReader(input)
+	jib := NewDecoderFromReader(r)
+	buf := make([]byte, 0, 256)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(input)
+		jib.Reset(r)
+		_, err := jib.Decode(buf[0:0])
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("expected no allocations per input, but got %v", allocs)
+	}
+}
+
+// decodeStream decodes objects until io.EOF and returns them as relaxed
+// Extended JSON.
+func decodeStream(t *testing.T, jib *Decoder) []string {
+	t.Helper()
+	output := make([]string, 0)
+	for {
+		buf, err := jib.Decode(nil)
+		if err == io.EOF {
+			return output
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		json, err := MarshalExtJSON(buf, nil, RelaxedExtJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output = append(output, string(json))
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	c.n++
+	return c.r.Read(b)
+}
+
+var errTestRead = errors.New("test read error")
+
+type errorReader struct{}
+
+func (errorReader) Read([]byte) (int, error) { return 0, errTestRead }
+
+// benchmarkInput returns a stream of documents, either one per line or
+// indented over many lines.
+func benchmarkInput(indented bool) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		doc := fmt.Sprintf(`{"_id":%d,"name":"user %d","active":%t,"score":%d.5,"tags":["a","b","c"],"address":{"street":"%d Main St","city":"Springfield","zip":"%05d"},"history":[{"t":1,"v":-2.5e3},{"t":2,"v":null}]}`,
+			i, i, i%2 == 0, i*7, i, i*13)
+		if indented {
+			_ = json.Indent(&buf, []byte(doc), "", "  ")
+		} else {
+			buf.WriteString(doc)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, indented := range []bool{false, true} {
+		label := "lines"
+		if indented {
+			label = "indented"
+		}
+		input := benchmarkInput(indented)
+		b.Run(label, func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			r := bytes.NewReader(input)
+			jib := NewDecoderFromReader(r)
+			var buf []byte
+			for i := 0; i < b.N; i++ {
+				r.Reset(input)
+				jib.Reset(r)
+				for {
+					var err error
+					buf, err = jib.Decode(buf[0:0])
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkNewDecoder measures the cost of starting a decoder for a small
+// input, with and without the copy of the input that recovery keeps.
+func BenchmarkNewDecoder(b *testing.B) {
+	input := []byte(`{"a":1,"b":"hello","c":[true,null,2.5]}`)
+	for _, recover := range []bool{false, true} {
+		label := "plain"
+		if recover {
+			label = "recover"
+		}
+		b.Run(label, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(input)))
+			r := bytes.NewReader(input)
+			var buf []byte
+			for i := 0; i < b.N; i++ {
+				r.Reset(input)
+				jib, err := NewDecoder(bufio.NewReader(r))
+				if err != nil {
+					b.Fatal(err)
+				}
+				jib.Recover(recover)
+				buf, err = jib.Decode(buf[0:0])
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
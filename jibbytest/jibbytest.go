@@ -0,0 +1,337 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// Package jibbytest runs conformance tests in the format of the MongoDB BSON
+// Corpus (https://github.com/mongodb/specifications/tree/master/source/bson-corpus)
+// against a jibby.Decoder configuration.
+//
+// Each corpus file is a JSON object with arrays of "valid", "decodeErrors" and
+// "parseErrors" cases.  Valid cases must decode from canonical and degenerate
+// Extended JSON to the canonical BSON, encode from the canonical BSON to
+// canonical and relaxed Extended JSON, and round trip relaxed Extended JSON.
+// Decode error cases are invalid BSON, which must fail to encode to JSON.
+// Parse error cases are invalid Extended JSON, which must fail to decode with
+// a jibby.ParseError.
+package jibbytest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/xdg-go/jibby"
+)
+
+// Kind identifies the kind of a corpus case.
+type Kind int
+
+// Kind constants.
+const (
+	// Valid cases convert between BSON and Extended JSON.
+	Valid Kind = iota
+	// DecodeError cases are invalid BSON.
+	DecodeError
+	// ParseError cases are invalid Extended JSON.
+	ParseError
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Valid:
+		return "valid"
+	case DecodeError:
+		return "decode errors"
+	case ParseError:
+		return "parse errors"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Result is the outcome of one corpus case.
+type Result struct {
+	// File is the base name of the corpus file.
+	File string
+	// Kind is the kind of the case.
+	Kind Kind
+	// Description is the description of the case from the corpus file.
+	Description string
+	// Err describes why the case failed.  It is nil if the case passed.
+	Err error
+}
+
+// Passed reports whether the case passed.
+func (r Result) Passed() bool {
+	return r.Err == nil
+}
+
+// RunDir runs the cases in each file in a directory with a `.json` suffix,
+// in the order of the file names.  Each Decoder has Extended JSON enabled
+// and is then passed to the configure function, if it isn't nil.  An error is
+// returned if a file can't be read or parsed.
+func RunDir(dir string, configure func(*jibby.Decoder)) ([]Result, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		fileResults, err := RunFile(filepath.Join(dir, f.Name()), configure)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, fileResults...)
+	}
+
+	return results, nil
+}
+
+// RunFile runs the cases in a corpus file, configuring each Decoder like
+// RunDir.  An error is returned if the file can't be read or parsed.
+func RunFile(path string, configure func(*jibby.Decoder)) ([]Result, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file corpusFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	r := runner{file: filepath.Base(path), configure: configure}
+	results := make([]Result, 0, len(file.Valid)+len(file.DecodeErrors)+len(file.ParseErrors))
+	for _, c := range file.Valid {
+		results = append(results, r.result(Valid, c.Description, r.runValid(c)))
+	}
+	for _, c := range file.DecodeErrors {
+		results = append(results, r.result(DecodeError, c.Description, r.runDecodeError(c)))
+	}
+	for _, c := range file.ParseErrors {
+		// Decimal128 parse errors are strings to parse as a Decimal128.
+		input := c.Input
+		if file.BSONType == "0x13" {
+			quoted, _ := json.Marshal(input)
+			input = fmt.Sprintf(`{"d":{"$numberDecimal":%s}}`, quoted)
+		}
+		results = append(results, r.result(ParseError, c.Description, r.runParseError(input)))
+	}
+
+	return results, nil
+}
+
+// Test runs the cases in a directory like RunDir, as subtests named for the
+// file, kind and description of each case.  Cases with a description that
+// contains any of the skip strings are skipped.
+func Test(t *testing.T, dir string, configure func(*jibby.Decoder), skip ...string) {
+	t.Helper()
+
+	results, err := RunDir(dir, configure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range results {
+		r := r
+		name := r.File + "/" + r.Kind.String() + "/" + r.Description
+		t.Run(name, func(t *testing.T) {
+			for _, s := range skip {
+				if strings.Contains(r.Description, s) {
+					t.Skip("skipped")
+				}
+			}
+			if r.Err != nil {
+				t.Error(r.Err)
+			}
+		})
+	}
+}
+
+type validCase struct {
+	Description       string `json:"description"`
+	CanonicalBSON     string `json:"canonical_bson"`
+	CanonicalExtJSON  string `json:"canonical_extjson"`
+	RelaxedExtJSON    string `json:"relaxed_extjson"`
+	DegenerateBSON    string `json:"degenerate_bson"`
+	DegenerateExtJSON string `json:"degenerate_extjson"`
+	Lossy             bool   `json:"lossy"`
+}
+
+type decodeErrorCase struct {
+	Description string `json:"description"`
+	BSON        string `json:"bson"`
+}
+
+type parseErrorCase struct {
+	Description string `json:"description"`
+	Input       string `json:"string"`
+}
+
+type corpusFile struct {
+	Description  string            `json:"description"`
+	BSONType     string            `json:"bson_type"`
+	TestKey      string            `json:"test_key"`
+	Valid        []validCase       `json:"valid"`
+	DecodeErrors []decodeErrorCase `json:"decodeErrors"`
+	ParseErrors  []parseErrorCase  `json:"parseErrors"`
+}
+
+type runner struct {
+	file      string
+	configure func(*jibby.Decoder)
+}
+
+func (r runner) result(kind Kind, description string, err error) Result {
+	return Result{File: r.file, Kind: kind, Description: description, Err: err}
+}
+
+// decode converts a single JSON document to BSON with a configured Decoder.
+func (r runner) decode(input string) ([]byte, error) {
+	jib, err := jibby.NewDecoder(bufio.NewReader(strings.NewReader(input)))
+	if err != nil {
+		return nil, err
+	}
+	jib.ExtJSON(true)
+	if r.configure != nil {
+		r.configure(jib)
+	}
+
+	out, err := jib.Decode(nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = jib.Decode(nil)
+	if err != io.EOF {
+		return nil, fmt.Errorf("expected a single document, but got %v", err)
+	}
+	return out, nil
+}
+
+func (r runner) runValid(c validCase) error {
+	bson, err := hex.DecodeString(c.CanonicalBSON)
+	if err != nil {
+		return fmt.Errorf("error decoding canonical_bson: %v", err)
+	}
+
+	if c.CanonicalExtJSON != "" && !c.Lossy {
+		err = r.compareDecode("canonical_extjson", c.CanonicalExtJSON, bson)
+		if err != nil {
+			return err
+		}
+	}
+	if c.DegenerateExtJSON != "" && !c.Lossy {
+		err = r.compareDecode("degenerate_extjson", c.DegenerateExtJSON, bson)
+		if err != nil {
+			return err
+		}
+	}
+	if c.CanonicalExtJSON != "" {
+		err = compareEncode("canonical_extjson", bson, c.CanonicalExtJSON, jibby.CanonicalExtJSON)
+		if err != nil {
+			return err
+		}
+	}
+	if c.RelaxedExtJSON != "" {
+		err = compareEncode("relaxed_extjson", bson, c.RelaxedExtJSON, jibby.RelaxedExtJSON)
+		if err != nil {
+			return err
+		}
+
+		// Relaxed Extended JSON must round trip.
+		roundTrip, err := r.decode(c.RelaxedExtJSON)
+		if err != nil {
+			return fmt.Errorf("decoding relaxed_extjson: %v", err)
+		}
+		err = compareEncode("relaxed_extjson round trip", roundTrip, c.RelaxedExtJSON, jibby.RelaxedExtJSON)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r runner) compareDecode(label, input string, expect []byte) error {
+	got, err := r.decode(input)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %v", label, err)
+	}
+	if !bytes.Equal(got, expect) {
+		return fmt.Errorf("decoding %s doesn't match canonical_bson:\nGot:    %s\nExpect: %s", label, hex.EncodeToString(got), hex.EncodeToString(expect))
+	}
+	return nil
+}
+
+func compareEncode(label string, bson []byte, output string, mode jibby.ExtJSONMode) error {
+	var expect bytes.Buffer
+	err := json.Compact(&expect, unescapeNonASCII([]byte(output)))
+	if err != nil {
+		return fmt.Errorf("error compacting %s: %v", label, err)
+	}
+
+	got, err := jibby.MarshalExtJSON(bson, nil, mode)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %v", label, err)
+	}
+	if !bytes.Equal(got, expect.Bytes()) {
+		return fmt.Errorf("encoding %s doesn't match:\nGot:    %s\nExpect: %s", label, got, expect.String())
+	}
+	return nil
+}
+
+func (r runner) runDecodeError(c decodeErrorCase) error {
+	bson, err := hex.DecodeString(c.BSON)
+	if err != nil {
+		return fmt.Errorf("error decoding bson: %v", err)
+	}
+	_, err = jibby.MarshalExtJSON(bson, nil, jibby.CanonicalExtJSON)
+	if err == nil {
+		return errors.New("expected error encoding invalid BSON, but got none")
+	}
+	return nil
+}
+
+func (r runner) runParseError(input string) error {
+	_, err := r.decode(input)
+	if err == nil {
+		return errors.New("expected parse error, but got none")
+	}
+	var pe *jibby.ParseError
+	if !errors.As(err, &pe) {
+		return fmt.Errorf("expected parse error, but got %v", err)
+	}
+	return nil
+}
+
+var nonASCIIEscapeRe = regexp.MustCompile(`\\u[0-9a-fA-F]{4}`)
+
+// unescapeNonASCII replaces `\uXXXX` escapes for non-ASCII, non-surrogate
+// characters with UTF-8, since the encoder only escapes control characters.
+func unescapeNonASCII(in []byte) []byte {
+	return nonASCIIEscapeRe.ReplaceAllFunc(in, func(esc []byte) []byte {
+		r, _ := strconv.ParseUint(string(esc[2:]), 16, 32)
+		if r < utf8.RuneSelf || utf16.IsSurrogate(rune(r)) {
+			return esc
+		}
+		return []byte(string(rune(r)))
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibbytest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xdg-go/jibby"
+)
+
+const corpusDir = "../testdata/mongodb-corpus/tests"
+
+// knownFailures are corpus cases that jibby doesn't pass.
+var knownFailures = []string{
+	"Bad $date (number, not string or hash)",
+}
+
+func TestCorpus(t *testing.T) {
+	Test(t, corpusDir, func(d *jibby.Decoder) { d.MaxDepth(1000) }, knownFailures...)
+}
+
+// TestRunDirResults checks that results report failures: a Decoder without
+// Extended JSON fails the valid cases for ObjectIDs.
+func TestRunDirResults(t *testing.T) {
+	results, err := RunDir(corpusDir, func(d *jibby.Decoder) { d.ExtJSON(false) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sawValidFailure bool
+	for _, r := range results {
+		if r.File == "oid.json" && r.Kind == Valid && !r.Passed() {
+			sawValidFailure = true
+		}
+		if r.File == "oid.json" && r.Kind == Valid && r.Passed() {
+			t.Errorf("expected %s to fail without Extended JSON", r.Description)
+		}
+	}
+	if !sawValidFailure {
+		t.Errorf("expected failed valid cases for oid.json")
+	}
+}
+
+func TestRunFileErrors(t *testing.T) {
+	_, err := RunFile("no-such-file.json", nil)
+	if err == nil {
+		t.Errorf("expected error for missing file, but got none")
+	}
+
+	_, err = RunFile("jibbytest.go", nil)
+	if err == nil || !strings.Contains(err.Error(), "jibbytest.go") {
+		t.Errorf("expected error naming invalid file, but got %v", err)
+	}
+}
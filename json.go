@@ -16,6 +16,7 @@ import (
 	"strings"
 	"unicode"
 	"unicode/utf16"
+	"unicode/utf8"
 )
 
 var errNullEscape = errors.New("string escape mapped to null byte")
@@ -56,6 +57,15 @@ func (d *Decoder) convertValue(out []byte, typeBytePos int) ([]byte, error) {
 			return nil, err
 		}
 	case 'n':
+		if d.shellMode {
+			// Could be `new Date(...)`
+			_ = d.json.UnreadByte()
+			out, err = d.convertShellValue(out, typeBytePos)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 		overwriteTypeByte(out, typeBytePos, bsonNull)
 		out, err = d.convertNull(out)
 		if err != nil {
@@ -67,18 +77,68 @@ func (d *Decoder) convertValue(out []byte, typeBytePos int) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
+	case '\'':
+		if !d.lenient {
+			return nil, d.parseError([]byte{ch}, "invalid character")
+		}
+		overwriteTypeByte(out, typeBytePos, bsonString)
+		out, err = d.convertSingleQuoted(out, d.convertString)
+		if err != nil {
+			return nil, err
+		}
+	case 'N', 'I', '+':
+		if d.shellMode && ch != '+' {
+			_ = d.json.UnreadByte()
+			out, err = d.convertShellValue(out, typeBytePos)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		if !d.lenient {
+			return nil, d.parseError([]byte{ch}, "invalid character")
+		}
+		_ = d.json.UnreadByte()
+		out, err = d.convertNonFinite(out, typeBytePos)
+		if err != nil {
+			return nil, err
+		}
 	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 		// This starts a number, so we unread the byte and let that handler give
 		// us any error.  We can't write the
 		// type byte until the number type is determined (int64, int32, double),
 		// so we pass in the type byte position.
 		_ = d.json.UnreadByte()
+		if d.lenient && ch == '-' {
+			if next, _ := d.json.Peek(2); len(next) == 2 && next[1] == 'I' {
+				out, err = d.convertNonFinite(out, typeBytePos)
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
 		out, err = d.convertNumber(out, typeBytePos)
 		if err != nil {
 			return nil, err
 		}
+	case '/':
+		if !d.shellMode {
+			return nil, d.parseError([]byte{ch}, "invalid character")
+		}
+		out, err = d.convertRegexLiteral(out, typeBytePos)
+		if err != nil {
+			return nil, err
+		}
 	default:
-		return nil, d.parseError([]byte{ch}, "invalid character")
+		if !d.shellMode || !isIdentifierStart(ch) {
+			return nil, d.parseError([]byte{ch}, "invalid character")
+		}
+		_ = d.json.UnreadByte()
+		out, err = d.convertShellValue(out, typeBytePos)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return out, nil
@@ -109,7 +169,15 @@ func (d *Decoder) convertObject(out []byte, outerTypeBytePos int) ([]byte, error
 	case '}':
 		// Empty object
 		overwriteTypeByte(out, outerTypeBytePos, bsonDocument)
-		out = append(out, emptyDoc...)
+		if !d.generatesID(outerTypeBytePos) {
+			out = append(out, emptyDoc...)
+			return out, nil
+		}
+		out = append(out, emptyLength...)
+		out = append(out, idPlaceholder...)
+		out = d.finishID(out, lengthPos+len(emptyLength))
+		out = append(out, nullByte)
+		overwriteLength(out, lengthPos, len(out)-lengthPos)
 		return out, nil
 	case '"':
 		// Put back quote for subsequent object parsing
@@ -133,7 +201,27 @@ func (d *Decoder) convertObject(out []byte, outerTypeBytePos int) ([]byte, error
 		overwriteTypeByte(out, outerTypeBytePos, bsonDocument)
 
 	default:
-		return nil, d.parseError([]byte{ch}, "expecting key or end of object")
+		if !d.lenient || !(ch == '\'' || isIdentifierStart(ch)) {
+			return nil, d.parseError([]byte{ch}, "expecting key or end of object")
+		}
+		// A single-quoted or unquoted key is never extended JSON.
+		_ = d.json.UnreadByte()
+		out = append(out, emptyLength...)
+		overwriteTypeByte(out, outerTypeBytePos, bsonDocument)
+	}
+
+	// Reserve space for an `_id` to add, since the document length is only
+	// written at the end.
+	idPos := -1
+	if d.generatesID(outerTypeBytePos) {
+		idPos = len(out)
+		out = append(out, idPlaceholder...)
+	}
+
+	// Track keys if they must be checked for duplicates
+	if d.duplicateKeys != AllowDuplicateKeys {
+		d.pushKeyFrame()
+		defer d.popKeyFrame()
 	}
 
 	// Convert the first element
@@ -151,6 +239,19 @@ LOOP:
 		}
 		switch ch {
 		case ',':
+			if d.lenient {
+				ended, err := d.endsAfterComma('}')
+				if err != nil {
+					return nil, err
+				}
+				if ended {
+					break LOOP
+				}
+			}
+			err = d.checkContext()
+			if err != nil {
+				return nil, err
+			}
 			// Convert next element
 			out, err = d.convertObjectElement(out)
 			if err != nil {
@@ -163,6 +264,10 @@ LOOP:
 		}
 	}
 
+	if idPos >= 0 {
+		out = d.finishID(out, idPos)
+	}
+
 	// Write null terminator and calculate/update length
 	out = append(out, nullByte)
 	objectLength := len(out) - lengthPos
@@ -177,22 +282,25 @@ func (d *Decoder) convertObjectElement(out []byte) ([]byte, error) {
 	if err != nil {
 		return nil, newReadError(err)
 	}
-	if ch != '"' {
-		return nil, d.parseError([]byte{ch}, "expecting opening quote of key")
-	}
 
 	// Record position for the placeholder type byte that we write
 	typeBytePos := len(out)
 	out = append(out, emptyType)
 
-	// Convert key as Cstring
-	out, err = d.convertCString(out)
+	// Convert key as Cstring, then apply any key policy to it
+	out, err = d.convertKey(out, ch)
 	if err != nil {
 		if err == errNullEscape {
 			return nil, d.parseError(nil, errNullEscape.Error())
 		}
 		return nil, err
 	}
+	if d.hasKeyPolicy() && d.curDepth != d.rawKeyDepth {
+		out, err = d.applyKeyPolicy(out, typeBytePos+1)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Next non-WS char must be ':' for separator
 	err = d.readNameSeparator()
@@ -200,8 +308,59 @@ func (d *Decoder) convertObjectElement(out []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	// Convert next value
+	// Apply the projection.  A skipped field's value isn't converted.
+	parentProjection := d.projectNode
+	if parentProjection != nil {
+		var skip bool
+		d.projectNode, skip = d.projectField(out[typeBytePos+1 : len(out)-1])
+		if skip {
+			d.projectNode = parentProjection
+			err = d.skipValue()
+			if err != nil {
+				return nil, prependPath(err, string(out[typeBytePos+1:len(out)-1]))
+			}
+			return out[0:typeBytePos], nil
+		}
+	}
+
+	// Apply the duplicate key policy, which may drop an earlier element
+	// and move this one.
+	var drop bool
+	if d.duplicateKeys != AllowDuplicateKeys {
+		out, typeBytePos, drop, err = d.checkDuplicateKey(out, typeBytePos)
+		if err != nil {
+			d.projectNode = parentProjection
+			return nil, err
+		}
+	}
+
+	// Convert next value.  On error, the key is added to the error path.
+	// If number types are set for fields, track the field for the value.
+	key := out[typeBytePos+1 : len(out)-1]
+	parentNode := d.numberNode
+	if d.numberPaths != nil {
+		d.numberNode = parentNode.child(key)
+	}
 	out, err = d.convertValue(out, typeBytePos)
+	d.numberNode = parentNode
+	projected := err == nil && d.dropsProjected(out, typeBytePos)
+	d.projectNode = parentProjection
+	if err != nil {
+		return nil, prependPath(err, string(key))
+	}
+
+	// An element dropped by the projection keeps its key for the duplicate
+	// key policy, which registered the element before its value was known.
+	if projected && !drop && d.duplicateKeys != AllowDuplicateKeys {
+		d.keyFrames[len(d.keyFrames)-1].drop(out)
+	}
+
+	// A dropped element is still converted so that it's validated.
+	if drop || projected {
+		out = out[0:typeBytePos]
+	}
+
+	err = d.checkDocumentSize(out)
 	if err != nil {
 		return nil, err
 	}
@@ -209,6 +368,22 @@ func (d *Decoder) convertObjectElement(out []byte) ([]byte, error) {
 	return out, nil
 }
 
+// convertKey converts a key to a C string, starting after the first character
+// of the key, which must be a quote unless parsing is lenient.
+func (d *Decoder) convertKey(out []byte, ch byte) ([]byte, error) {
+	switch {
+	case ch == '"':
+		return d.convertCString(out)
+	case d.lenient && ch == '\'':
+		return d.convertSingleQuoted(out, d.convertCString)
+	case d.lenient && isIdentifierStart(ch):
+		_ = d.json.UnreadByte()
+		return d.convertIdentifier(out)
+	default:
+		return nil, d.parseError([]byte{ch}, "expecting opening quote of key")
+	}
+}
+
 // convertArray starts after the opening bracket of an array.
 func (d *Decoder) convertArray(out []byte) ([]byte, error) {
 	var ch byte
@@ -241,12 +416,17 @@ func (d *Decoder) convertArray(out []byte) ([]byte, error) {
 	// Not empty: unread the byte for convertValue to check
 	_ = d.json.UnreadByte()
 
-	// Convert the first value
-	index := 0
-	out, err = d.convertArrayElement(out, index)
+	// Convert the first value.  Elements dropped by a projection are not
+	// counted for keys.
+	index, count := 0, 0
+	start := len(out)
+	out, err = d.convertArrayElement(out, index, count)
 	if err != nil {
 		return nil, err
 	}
+	if len(out) > start {
+		count++
+	}
 
 	// Loop, looking for separators or array terminator
 LOOP:
@@ -258,12 +438,29 @@ LOOP:
 
 		switch ch {
 		case ',':
+			if d.lenient {
+				ended, err := d.endsAfterComma(']')
+				if err != nil {
+					return nil, err
+				}
+				if ended {
+					break LOOP
+				}
+			}
+			err = d.checkContext()
+			if err != nil {
+				return nil, err
+			}
 			// Convert the next value
 			index++
-			out, err = d.convertArrayElement(out, index)
+			start = len(out)
+			out, err = d.convertArrayElement(out, index, count)
 			if err != nil {
 				return nil, err
 			}
+			if len(out) > start {
+				count++
+			}
 		case ']':
 			break LOOP
 		default:
@@ -279,21 +476,31 @@ LOOP:
 	return out, nil
 }
 
-func (d *Decoder) convertArrayElement(out []byte, index int) ([]byte, error) {
+// convertArrayElement converts the element at an index in the input, with an
+// index key for the output, which differs if a projection dropped elements.
+func (d *Decoder) convertArrayElement(out []byte, index int, key int) ([]byte, error) {
 	// Record position for the placeholder type byte
 	typeBytePos := len(out)
 	out = append(out, emptyType)
 
 	// Append next key
-	if index < len(arrayKey) {
-		out = append(out, arrayKey[index]...)
+	if key < len(arrayKey) {
+		out = append(out, arrayKey[key]...)
 	} else {
-		out = append(out, []byte(strconv.Itoa(index))...)
+		out = append(out, []byte(strconv.Itoa(key))...)
 	}
 	out = append(out, nullByte)
 
-	// Convert next value
+	// Convert next value.  On error, the index is added to the error path.
 	out, err := d.convertValue(out, typeBytePos)
+	if err != nil {
+		return nil, prependPath(err, strconv.Itoa(index))
+	}
+	if d.dropsProjected(out, typeBytePos) {
+		return out[0:typeBytePos], nil
+	}
+
+	err = d.checkDocumentSize(out)
 	if err != nil {
 		return nil, err
 	}
@@ -369,17 +576,18 @@ func (d *Decoder) convertNumber(out []byte, typeBytePos int) ([]byte, error) {
 		return nil, err
 	}
 
-	if isFloat {
+	numberType := d.currentNumberType()
+	switch {
+	case numberType == NumbersAsDecimal128:
+		out, err = d.convertDecimal128(out, typeBytePos, buf)
+	case isFloat || numberType == NumbersAsDouble:
 		out, err = d.convertFloat(out, typeBytePos, buf)
-		if err != nil {
-			return nil, err
-		}
-	} else {
+	default:
 		// Still don't know if the type is int32 or int64, so delegate.
-		out, err = d.convertInt(out, typeBytePos, buf)
-		if err != nil {
-			return nil, err
-		}
+		out, err = d.convertInt(out, typeBytePos, buf, numberType == IntsAsInt64)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	_, _ = d.json.Discard(len(buf))
@@ -392,9 +600,24 @@ func (d *Decoder) convertNumber(out []byte, typeBytePos int) ([]byte, error) {
 func (d *Decoder) convertFloat(out []byte, typeBytePos int, buf []byte) ([]byte, error) {
 	n, err := strconv.ParseFloat(string(buf), 64)
 	if err != nil {
+		if d.numberPolicy == PreciseToDecimal128 && strings.Contains(err.Error(), strconv.ErrRange.Error()) {
+			return d.convertDecimal128(out, typeBytePos, buf)
+		}
 		return nil, d.parseError(nil, fmt.Sprintf("float conversion: %v", err))
 	}
 
+	// Check for precision loss only if the policy needs it.
+	switch d.numberPolicy {
+	case PreciseToDecimal128:
+		if !isExactFloat(buf, n) {
+			return d.convertDecimal128(out, typeBytePos, buf)
+		}
+	case ErrorOnPrecisionLoss:
+		if !isExactFloat(buf, n) {
+			return nil, d.parseError(nil, "number can't be represented as a double without losing precision")
+		}
+	}
+
 	overwriteTypeByte(out, typeBytePos, bsonDouble)
 	var x [8]byte
 	xs := x[0:8]
@@ -403,19 +626,23 @@ func (d *Decoder) convertFloat(out []byte, typeBytePos int, buf []byte) ([]byte,
 	return out, nil
 }
 
-// convertInt converts the integer number in the buffer.  It does not consume
-// any of the input.
-func (d *Decoder) convertInt(out []byte, typeBytePos int, buf []byte) ([]byte, error) {
+// convertInt converts the integer number in the buffer to an int32 if it fits,
+// unless forced to int64.  It does not consume any of the input.
+func (d *Decoder) convertInt(out []byte, typeBytePos int, buf []byte, forceInt64 bool) ([]byte, error) {
 	n, err := strconv.ParseInt(string(buf), 10, 64)
 	if err != nil {
 		if strings.Contains(err.Error(), strconv.ErrRange.Error()) {
-			// Doesn't fit in int64, so treat as float
+			// Doesn't fit in int64, so treat as Decimal128 or float, as
+			// the number policy allows.
+			if d.numberPolicy == BigIntsToDecimal128 || d.numberPolicy == PreciseToDecimal128 {
+				return d.convertDecimal128(out, typeBytePos, buf)
+			}
 			return d.convertFloat(out, typeBytePos, buf)
 		}
 		return nil, d.parseError(nil, fmt.Sprintf("int conversion: %v", err))
 	}
 
-	if n < math.MinInt32 || n > math.MaxInt32 {
+	if forceInt64 || n < math.MinInt32 || n > math.MaxInt32 {
 		overwriteTypeByte(out, typeBytePos, bsonInt64)
 		var x [8]byte
 		xs := x[0:8]
@@ -482,6 +709,13 @@ func (d *Decoder) convertCString(out []byte) ([]byte, error) {
 				case '"', '\\', '/':
 					out = append(out, buf[i+1])
 					i++
+				case '\'':
+					if !d.lenient {
+						_, _ = d.json.Discard(i)
+						return nil, d.parseError(nil, "unknown escape '''")
+					}
+					out = append(out, '\'')
+					i++
 				case 'b':
 					out = append(out, '\b')
 					i++
@@ -576,6 +810,17 @@ func (d *Decoder) convertCString(out []byte) ([]byte, error) {
 				// Escape is done: go back to needing only one char at a time.
 				charsNeeded = 1
 			case '"':
+				if d.singleQuoted {
+					out = append(out, '"')
+					break
+				}
+				terminated = true
+				break INNER
+			case '\'':
+				if !d.singleQuoted {
+					out = append(out, '\'')
+					break
+				}
 				terminated = true
 				break INNER
 			default:
@@ -583,7 +828,28 @@ func (d *Decoder) convertCString(out []byte) ([]byte, error) {
 					_, _ = d.json.Discard(i)
 					return nil, d.parseError(nil, "control characters not allowed in strings")
 				}
-				out = append(out, buf[i])
+				if buf[i] < utf8.RuneSelf || d.invalidUTF8 == AllowInvalidUTF8 {
+					out = append(out, buf[i])
+					break
+				}
+				// If a multi-byte character may be split across chunks,
+				// break out and repeek from its start.
+				if !utf8.FullRune(buf[i:]) {
+					charsNeeded = utf8SequenceLength(buf[i])
+					break INNER
+				}
+				r, size := utf8.DecodeRune(buf[i:])
+				if r == utf8.RuneError && size == 1 {
+					if d.invalidUTF8 == ErrorOnInvalidUTF8 {
+						_, _ = d.json.Discard(i)
+						return nil, d.parseError(nil, "invalid UTF-8 in string")
+					}
+					out = append(out, replacementChar...)
+				} else {
+					out = append(out, buf[i:i+size]...)
+					i += size - 1
+				}
+				charsNeeded = 1
 			}
 		}
 
@@ -593,6 +859,11 @@ func (d *Decoder) convertCString(out []byte) ([]byte, error) {
 			_, _ = d.json.Discard(i + 1)
 		} else {
 			_, _ = d.json.Discard(i)
+			// Don't wait for the end of a long string to check its size.
+			err = d.checkDocumentSize(out)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -623,3 +894,180 @@ func (d *Decoder) convertString(out []byte) ([]byte, error) {
 
 	return out, nil
 }
+
+// skipValue consumes a JSON value from the input stream without converting
+// it.  It only checks as much as needed to find the end of the value: strings
+// must be terminated and objects and arrays must be nested properly.  Scalars
+// are not validated.
+func (d *Decoder) skipValue() error {
+	ch, err := d.readAfterWS()
+	if err != nil {
+		return newReadError(err)
+	}
+
+	switch ch {
+	case '{', '[':
+		return d.skipContainer(ch)
+	case '"':
+		return d.skipString(ch)
+	case ',', ':', '}', ']':
+		return d.parseError([]byte{ch}, "invalid character")
+	default:
+		if d.lenient && ch == '\'' {
+			return d.skipString(ch)
+		}
+		if d.shellMode && (isIdentifierStart(ch) || ch == '/') {
+			return d.skipShellValue(ch)
+		}
+		// Scalars end at white space, a separator or a terminator, which is
+		// left in the input stream.  In lenient mode, they may also end at
+		// a comment.
+		for {
+			ch, err = d.json.ReadByte()
+			if err != nil {
+				if err == io.EOF && d.curDepth == 0 {
+					return nil
+				}
+				return newReadError(err)
+			}
+			switch ch {
+			case ' ', '\t', '\n', '\r', ',', ':', ']', '}':
+				_ = d.json.UnreadByte()
+				return nil
+			case '/':
+				if d.lenient {
+					_ = d.json.UnreadByte()
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// skipString starts after the opening quote of a string and consumes it,
+// including the closing quote.
+func (d *Decoder) skipString(quote byte) error {
+	var escaped bool
+	for {
+		buf, err := d.json.Peek(64)
+		if len(buf) == 0 {
+			return newReadError(err)
+		}
+		for i, ch := range buf {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == quote:
+				_, _ = d.json.Discard(i + 1)
+				return nil
+			}
+		}
+		_, _ = d.json.Discard(len(buf))
+	}
+}
+
+// skipContainer starts after the opening brace or bracket of an object or
+// array and consumes it, including the closing brace or bracket.  In lenient
+// mode, it also skips single-quoted strings and comments.  In shell mode, it
+// also skips regular expression literals.
+func (d *Decoder) skipContainer(open byte) error {
+	stack := append(d.skipStack[0:0], open)
+	defer func() { d.skipStack = stack }()
+
+	var quote byte
+	var escaped, slash, star, lineComment, blockComment, regex, class bool
+	for {
+		buf, err := d.json.Peek(64)
+		if len(buf) == 0 {
+			return newReadError(err)
+		}
+		for i, ch := range buf {
+			switch {
+			case quote != 0:
+				switch {
+				case escaped:
+					escaped = false
+				case ch == '\\':
+					escaped = true
+				case ch == quote:
+					quote = 0
+				}
+				continue
+			case regex:
+				switch {
+				case escaped:
+					escaped = false
+				case ch == '\\':
+					escaped = true
+				case ch == '[':
+					class = true
+				case ch == ']':
+					class = false
+				case ch == '/' && !class:
+					regex = false
+				}
+				continue
+			case lineComment:
+				lineComment = ch != '\n'
+				continue
+			case blockComment:
+				blockComment = !(star && ch == '/')
+				star = ch == '*'
+				continue
+			case slash:
+				// Only set in lenient mode.  A '/' that doesn't start a
+				// comment starts a regular expression in shell mode and is
+				// otherwise left for the parser to reject.
+				slash = false
+				switch ch {
+				case '/':
+					lineComment = true
+					continue
+				case '*':
+					blockComment, star = true, false
+					continue
+				}
+				if d.shellMode {
+					regex = true
+					escaped = ch == '\\'
+					class = ch == '['
+					continue
+				}
+			}
+
+			switch ch {
+			case '"':
+				quote = ch
+			case '\'':
+				if d.lenient {
+					quote = ch
+				}
+			case '/':
+				if d.lenient {
+					slash = true
+				} else if d.shellMode {
+					regex = true
+				}
+			case '{', '[':
+				if d.curDepth+len(stack) >= d.maxDepth {
+					return errors.New("maximum depth exceeded")
+				}
+				stack = append(stack, ch)
+			case '}', ']':
+				top := stack[len(stack)-1]
+				if (top == '{' && ch != '}') || (top == '[' && ch != ']') {
+					_, _ = d.json.Discard(i)
+					return d.parseError(nil, "mismatched end of object or array")
+				}
+				stack = stack[0 : len(stack)-1]
+				if len(stack) == 0 {
+					_, _ = d.json.Discard(i + 1)
+					return nil
+				}
+			}
+		}
+		_, _ = d.json.Discard(len(buf))
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DuplicateKeyPolicy determines how a Decoder handles objects with repeated
+// keys.
+type DuplicateKeyPolicy int
+
+// DuplicateKeyPolicy constants.
+const (
+	// AllowDuplicateKeys copies every element, so a repeated key is repeated
+	// in the BSON document.  This is the default.
+	AllowDuplicateKeys DuplicateKeyPolicy = iota
+	// ErrorOnDuplicateKeys returns a ParseError for a repeated key.
+	ErrorOnDuplicateKeys
+	// FirstKeyWins keeps the first element with a key and drops the rest.
+	FirstKeyWins
+	// LastKeyWins keeps the last element with a key and drops the earlier
+	// ones, so the element is at the position of the last occurrence.
+	LastKeyWins
+)
+
+// DuplicateKeys sets the policy for objects with repeated keys.  Repeated keys
+// are allowed by default.  With other policies, each element of an object is
+// checked against the earlier ones.
+func (d *Decoder) DuplicateKeys(policy DuplicateKeyPolicy) {
+	d.duplicateKeys = policy
+}
+
+// Objects with more than this many keys are checked for duplicates with a
+// map rather than a linear scan.
+const keyIndexThreshold = 16
+
+// keyFrame records where the elements of an object being converted start in
+// the output, to check for duplicate keys.  A removed or dropped element keeps
+// its place with a negative position, so the index of each element never
+// changes.
+type keyFrame struct {
+	elements []int
+	index    map[string]int
+}
+
+// pushKeyFrame starts tracking keys for a new object.
+func (d *Decoder) pushKeyFrame() {
+	n := len(d.keyFrames)
+	if n < cap(d.keyFrames) {
+		d.keyFrames = d.keyFrames[0 : n+1]
+		d.keyFrames[n].elements = d.keyFrames[n].elements[0:0]
+		d.keyFrames[n].index = nil
+	} else {
+		d.keyFrames = append(d.keyFrames, keyFrame{})
+	}
+}
+
+// popKeyFrame stops tracking keys for the current object.
+func (d *Decoder) popKeyFrame() {
+	d.keyFrames = d.keyFrames[0 : len(d.keyFrames)-1]
+}
+
+// elementKey returns the key of the element starting at a position in the
+// output.
+func elementKey(out []byte, pos int) []byte {
+	key := out[pos+1:]
+	return key[0:bytes.IndexByte(key, nullByte)]
+}
+
+// checkDuplicateKey applies the duplicate key policy to a new element of the
+// current object, after its type byte and key have been written to the output.
+// It returns the output and the new element's position, which change if an
+// earlier element is dropped, and whether the new element should be dropped
+// after its value is converted.
+func (d *Decoder) checkDuplicateKey(out []byte, typeBytePos int) ([]byte, int, bool, error) {
+	frame := &d.keyFrames[len(d.keyFrames)-1]
+	key := out[typeBytePos+1 : len(out)-1]
+
+	i := frame.find(out, key)
+	if i < 0 {
+		frame.add(out, typeBytePos)
+		return out, typeBytePos, false, nil
+	}
+
+	switch d.duplicateKeys {
+	case ErrorOnDuplicateKeys:
+		return nil, 0, false, prependPath(d.parseError(nil, fmt.Sprintf("duplicate key %q", key)), string(key))
+	case FirstKeyWins:
+		return out, typeBytePos, true, nil
+	default:
+		// LastKeyWins: remove the earlier element and shift the rest down.
+		// The object's length isn't written until it ends, so only this
+		// object's element positions must be updated.  An element dropped by
+		// a projection has nothing to remove.
+		if frame.elements[i] < 0 {
+			frame.add(out, typeBytePos)
+			return out, typeBytePos, false, nil
+		}
+		start := frame.elements[i]
+		end := frame.end(i, typeBytePos)
+		size := end - start
+		out = out[0 : start+copy(out[start:], out[end:])]
+		frame.remove(i, size)
+		typeBytePos -= size
+		frame.add(out, typeBytePos)
+		return out, typeBytePos, false, nil
+	}
+}
+
+// find returns the index of the element with a key, or -1 if none exists.
+func (f *keyFrame) find(out []byte, key []byte) int {
+	if f.index != nil {
+		i, ok := f.index[string(key)]
+		if !ok {
+			return -1
+		}
+		return i
+	}
+	for i, pos := range f.elements {
+		if pos >= 0 && bytes.Equal(elementKey(out, pos), key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// add records a new element, indexing keys once there are many of them.  A
+// key that is already indexed is replaced.
+func (f *keyFrame) add(out []byte, pos int) {
+	f.elements = append(f.elements, pos)
+	switch {
+	case f.index != nil:
+		f.index[string(elementKey(out, pos))] = len(f.elements) - 1
+	case len(f.elements) > keyIndexThreshold:
+		f.index = make(map[string]int, 2*len(f.elements))
+		for i, p := range f.elements {
+			if p >= 0 {
+				f.index[string(elementKey(out, p))] = i
+			}
+		}
+	}
+}
+
+// end returns the position where the element at an index ends: the start of
+// the next element that wasn't removed, or a given position if there is none.
+func (f *keyFrame) end(i int, last int) int {
+	for _, pos := range f.elements[i+1:] {
+		if pos >= 0 {
+			return pos
+		}
+	}
+	return last
+}
+
+// drop marks the last element added as dropped from the output, keeping its
+// key indexed, so a later element with the key is still a duplicate.  It must
+// be called before the element is removed from the output.
+func (f *keyFrame) drop(out []byte) {
+	i := len(f.elements) - 1
+	if f.index == nil {
+		f.index = make(map[string]int, 2*len(f.elements))
+		for j, p := range f.elements[0:i] {
+			if p >= 0 {
+				f.index[string(elementKey(out, p))] = j
+			}
+		}
+	}
+	f.index[string(elementKey(out, f.elements[i]))] = i
+	f.elements[i] = -1
+}
+
+// remove marks the element at an index as removed and shifts the positions of
+// later elements down by its size.  Its key stays in the index until the
+// element that replaces it is added.
+func (f *keyFrame) remove(i int, size int) {
+	f.elements[i] = -1
+	for j := i + 1; j < len(f.elements); j++ {
+		if f.elements[j] >= 0 {
+			f.elements[j] -= size
+		}
+	}
+}
+
+// SpecialKeyPolicy determines how a Decoder handles keys that MongoDB treats
+// specially: keys that contain `.` or start with `$`.
+type SpecialKeyPolicy int
+
+// SpecialKeyPolicy constants.
+const (
+	// AllowSpecialKeys copies keys unchanged.  This is the default.
+	AllowSpecialKeys SpecialKeyPolicy = iota
+	// ErrorOnSpecialKeys returns a ParseError for a special key.
+	ErrorOnSpecialKeys
+	// EscapeSpecialKeys replaces each `.` in a key and a leading `$` with
+	// the escapes set by SpecialKeyEscapes.
+	EscapeSpecialKeys
+)
+
+// Default escapes for EscapeSpecialKeys are the full-width forms of `.` and
+// `$`.
+const (
+	defaultDotEscape    = "\uFF0E"
+	defaultDollarEscape = "\uFF04"
+)
+
+// SpecialKeys sets the policy for keys that contain `.` or start with `$`.
+// Extended JSON keys, like `$oid`, are interpreted before the policy applies,
+// so they are only special keys if ExtJSON is off or they aren't valid
+// Extended JSON.
+func (d *Decoder) SpecialKeys(policy SpecialKeyPolicy) {
+	d.specialKeys = policy
+}
+
+// SpecialKeyEscapes sets the replacements for `.` and a leading `$` in keys
+// with the EscapeSpecialKeys policy.  By default, they are U+FF0E and U+FF04,
+// the full-width forms of those characters.  An escape may be empty to remove
+// the character, but it must not contain a null byte.
+func (d *Decoder) SpecialKeyEscapes(dot, dollar string) error {
+	if strings.IndexByte(dot, nullByte) >= 0 || strings.IndexByte(dollar, nullByte) >= 0 {
+		return errors.New("key escapes must not contain null bytes")
+	}
+	d.dotEscape, d.dollarEscape = dot, dollar
+	return nil
+}
+
+// RenameKeys sets a table of keys to rename in objects at any depth, from the
+// original key to its replacement.  Renaming happens before the special key
+// policy is applied, so it can replace special keys with ordinary ones.  Other
+// options that match keys, like DuplicateKeys, Projection and FieldNumberType,
+// see the final keys.  A nil or empty table renames nothing.
+func (d *Decoder) RenameKeys(table map[string]string) error {
+	for from, to := range table {
+		if strings.IndexByte(from, nullByte) >= 0 || strings.IndexByte(to, nullByte) >= 0 {
+			return fmt.Errorf("key rename %q to %q: keys must not contain null bytes", from, to)
+		}
+	}
+	if len(table) == 0 {
+		table = nil
+	}
+	d.keyRenames = table
+	return nil
+}
+
+// hasKeyPolicy reports whether keys must be renamed or checked when written.
+func (d *Decoder) hasKeyPolicy() bool {
+	return d.keyRenames != nil || d.specialKeys != AllowSpecialKeys
+}
+
+// applyKeyPolicy renames, checks or escapes the key that was just written to
+// the output as a C string starting at a position.
+func (d *Decoder) applyKeyPolicy(out []byte, start int) ([]byte, error) {
+	key := out[start : len(out)-1]
+	if to, ok := d.keyRenames[string(key)]; ok {
+		out = append(out[0:start], to...)
+		out = append(out, nullByte)
+		key = out[start : len(out)-1]
+	}
+
+	if d.specialKeys == AllowSpecialKeys || !isSpecialKey(key) {
+		return out, nil
+	}
+	if d.specialKeys == ErrorOnSpecialKeys {
+		return nil, prependPath(d.parseError(nil, fmt.Sprintf("key %q contains '.' or starts with '$'", key)), string(key))
+	}
+
+	// Copy the key so it can be rewritten in place.
+	scratchP := d.scratchPool.Get().(*[]byte)
+	defer func() { d.scratchPool.Put(scratchP) }()
+	orig := append((*scratchP)[0:0], key...)
+
+	out = out[0:start]
+	for i, c := range orig {
+		switch {
+		case c == '.':
+			out = append(out, d.dotEscape...)
+		case c == '$' && i == 0:
+			out = append(out, d.dollarEscape...)
+		default:
+			out = append(out, c)
+		}
+	}
+	out = append(out, nullByte)
+	return out, nil
+}
+
+func isSpecialKey(key []byte) bool {
+	return (len(key) > 0 && key[0] == '$') || bytes.IndexByte(key, '.') >= 0
+}
+
+// appendWithKeyPolicy appends a BSON document that was converted without
+// applying the key policy to its own keys, applying it now.  This is needed
+// for objects that are converted to check for legacy Extended JSON.
+func (d *Decoder) appendWithKeyPolicy(out []byte, doc []byte) ([]byte, error) {
+	if !d.hasKeyPolicy() {
+		return append(out, doc...), nil
+	}
+
+	lengthPos := len(out)
+	out = append(out, emptyLength...)
+	elements, err := bson.Raw(doc).Elements()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range elements {
+		out = append(out, e[0])
+		start := len(out)
+		out = append(out, e.Key()...)
+		out = append(out, nullByte)
+		out, err = d.applyKeyPolicy(out, start)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e.Value().Value...)
+	}
+	out = append(out, nullByte)
+	overwriteLength(out, lengthPos, len(out)-lengthPos)
+	return out, nil
+}
@@ -0,0 +1,363 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// wideObject returns an object with n keys and the first key repeated at
+// the end, to exercise the key index.
+func wideObject(n int) (string, string) {
+	var sb strings.Builder
+	sb.WriteString(`{`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, `"k%d":%d,`, i, i)
+	}
+	input := sb.String() + `"k0":"last"}`
+	output := `{` + sb.String()[len(`{"k0":0,`):] + `"k0":"last"}`
+	return input, output
+}
+
+// TestDuplicateKeys checks each duplicate key policy.
+func TestDuplicateKeys(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label  string
+		policy DuplicateKeyPolicy
+		input  string
+		output string
+		errStr string
+	}
+
+	wideInput, wideOutput := wideObject(40)
+
+	cases := []testCase{
+		{
+			label:  "allow",
+			policy: AllowDuplicateKeys,
+			input:  `{"a":1,"b":2,"a":3}`,
+			output: `{"a":1,"b":2,"a":3}`,
+		},
+		{
+			label:  "error",
+			policy: ErrorOnDuplicateKeys,
+			input:  `{"a":1,"b":{"c":true,"c":false}}`,
+			errStr: `duplicate key "c"`,
+		},
+		{
+			label:  "error no duplicates",
+			policy: ErrorOnDuplicateKeys,
+			input:  `{"a":{"a":{"a":1}},"b":[{"a":1},{"a":2}]}`,
+			output: `{"a":{"a":{"a":1}},"b":[{"a":1},{"a":2}]}`,
+		},
+		{
+			label:  "first wins",
+			policy: FirstKeyWins,
+			input:  `{"a":1,"b":2,"a":{"x":[1,2]},"c":3,"b":4}`,
+			output: `{"a":1,"b":2,"c":3}`,
+		},
+		{
+			label:  "first wins validates dropped value",
+			policy: FirstKeyWins,
+			input:  `{"a":1,"a":[1,}`,
+			errStr: "invalid character",
+		},
+		{
+			label:  "last wins",
+			policy: LastKeyWins,
+			input:  `{"a":{"x":[1,2]},"b":2,"a":1,"c":3,"b":"four"}`,
+			output: `{"a":1,"c":3,"b":"four"}`,
+		},
+		{
+			label:  "last wins nested",
+			policy: LastKeyWins,
+			input:  `{"a":{"b":1,"b":{"c":1,"c":2}},"a":{"b":{"c":3,"d":4,"c":5}}}`,
+			output: `{"a":{"b":{"d":4,"c":5}}}`,
+		},
+		{
+			label:  "last wins repeated",
+			policy: LastKeyWins,
+			input:  `{"a":1,"a":2,"a":3}`,
+			output: `{"a":3}`,
+		},
+		{
+			label:  "last wins escaped key",
+			policy: LastKeyWins,
+			input:  `{"ab":1,"a\u0062":2}`,
+			output: `{"ab":2}`,
+		},
+		{
+			label:  "last wins wide object",
+			policy: LastKeyWins,
+			input:  wideInput,
+			output: wideOutput,
+		},
+		{
+			label:  "error wide object",
+			policy: ErrorOnDuplicateKeys,
+			input:  wideInput,
+			errStr: `duplicate key "k0"`,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(c.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jib.DuplicateKeys(c.policy)
+			buf, err := jib.Decode(nil)
+			if c.errStr != "" {
+				if err == nil {
+					t.Fatalf("expected error with '%s', but got none", c.errStr)
+				}
+				if !strings.Contains(err.Error(), c.errStr) {
+					t.Errorf("expected error with '%s', but got %v", c.errStr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			json, err := Marshal(buf, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(json) != c.output {
+				t.Errorf("expected %s, but got %s", c.output, json)
+			}
+		})
+	}
+}
+
+// TestDuplicateKeyErrorPath checks the position and path reported for a
+// duplicate key.
+func TestDuplicateKeyErrorPath(t *testing.T) {
+	t.Parallel()
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(`{"a":[{"b":1,"b":2}]}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jib.DuplicateKeys(ErrorOnDuplicateKeys)
+	_, err = jib.Decode(nil)
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected ParseError, but got %v", err)
+	}
+	if pe.Path != "/a/0/b" {
+		t.Errorf("expected path /a/0/b, but got %s", pe.Path)
+	}
+	if pe.Offset != 17 {
+		t.Errorf("expected offset 17, but got %d", pe.Offset)
+	}
+}
+
+// TestKeyPolicy checks special key policies and key renaming.
+func TestKeyPolicy(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label   string
+		policy  SpecialKeyPolicy
+		escapes []string
+		renames map[string]string
+		extJSON bool
+		input   string
+		output  string
+		errStr  string
+		errPath string
+	}
+
+	cases := []testCase{
+		{
+			label:  "allow",
+			policy: AllowSpecialKeys,
+			input:  `{"a.b":1,"$c":{"d$":2}}`,
+			output: `{"a.b":1,"$c":{"d$":2}}`,
+		},
+		{
+			label:   "error on dot",
+			policy:  ErrorOnSpecialKeys,
+			input:   `{"a":{"b.c":1}}`,
+			errStr:  `key "b.c" contains '.' or starts with '$'`,
+			errPath: "/a/b.c",
+		},
+		{
+			label:   "error on dollar",
+			policy:  ErrorOnSpecialKeys,
+			input:   `{"a":[{"$meta":1}]}`,
+			errStr:  `key "$meta" contains '.' or starts with '$'`,
+			errPath: "/a/0/$meta",
+		},
+		{
+			label:  "error allows inner dollar",
+			policy: ErrorOnSpecialKeys,
+			input:  `{"a$":1,"b":{"$":2}}`,
+			errStr: `key "$" contains`,
+		},
+		{
+			label:  "escape with defaults",
+			policy: EscapeSpecialKeys,
+			input:  `{"a.b.c":1,"$d$":2,"e":3}`,
+			output: `{"a．b．c":1,"＄d$":2,"e":3}`,
+		},
+		{
+			label:   "escape with custom mapping",
+			policy:  EscapeSpecialKeys,
+			escapes: []string{"_dot_", ""},
+			input:   `{"a.b":{"$c.":1}}`,
+			output:  `{"a_dot_b":{"c_dot_":1}}`,
+		},
+		{
+			label:   "rename",
+			renames: map[string]string{"old": "new", "a.b": "a_b"},
+			input:   `{"old":{"old":1},"a.b":2,"keep":3}`,
+			output:  `{"new":{"new":1},"a_b":2,"keep":3}`,
+		},
+		{
+			label:   "rename before policy",
+			policy:  ErrorOnSpecialKeys,
+			renames: map[string]string{"$meta": "meta", "ok": "$bad"},
+			input:   `{"$meta":1,"ok":2}`,
+			errStr:  `key "$bad"`,
+		},
+		{
+			label:   "extended JSON keys are exempt",
+			policy:  ErrorOnSpecialKeys,
+			extJSON: true,
+			input:   `{"a":{"$oid":"5f0c6f2e8f1b2c3d4e5f6a7b"},"b":{"$binary":"AQID","$type":"00"},"c":{"$regex":"x","$options":"im"}}`,
+			output:  `{"a":{"$oid":"5f0c6f2e8f1b2c3d4e5f6a7b"},"b":{"$binary":{"base64":"AQID","subType":"00"}},"c":{"$regularExpression":{"pattern":"x","options":"im"}}}`,
+		},
+		{
+			label:   "legacy extended JSON check",
+			policy:  EscapeSpecialKeys,
+			extJSON: true,
+			input:   `{"a":{"$type":"string","x.y":{"$z":1}}}`,
+			output:  `{"a":{"＄type":"string","x．y":{"＄z":1}}}`,
+		},
+		{
+			label:   "query operator not extended JSON",
+			policy:  ErrorOnSpecialKeys,
+			extJSON: true,
+			input:   `{"a":{"$regex":"x","$options":"i","$other":1}}`,
+			errStr:  `key "$regex"`,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(c.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jib.ExtJSON(c.extJSON)
+			jib.SpecialKeys(c.policy)
+			if c.escapes != nil {
+				err = jib.SpecialKeyEscapes(c.escapes[0], c.escapes[1])
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = jib.RenameKeys(c.renames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf, err := jib.Decode(nil)
+			if c.errStr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errStr) {
+					t.Fatalf("expected error with '%s', but got %v", c.errStr, err)
+				}
+				var pe *ParseError
+				if !errors.As(err, &pe) {
+					t.Fatalf("expected ParseError, but got %v", err)
+				}
+				if c.errPath != "" && pe.Path != c.errPath {
+					t.Errorf("expected path %s, but got %s", c.errPath, pe.Path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			json, err := MarshalExtJSON(buf, nil, RelaxedExtJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(json) != c.output {
+				t.Errorf("expected %s, but got %s", c.output, json)
+			}
+		})
+	}
+}
+
+func TestKeyPolicyErrors(t *testing.T) {
+	t.Parallel()
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(`{}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = jib.SpecialKeyEscapes("\x00", "")
+	if err == nil {
+		t.Errorf("expected error for null byte in escape, but got none")
+	}
+	err = jib.RenameKeys(map[string]string{"a": "b\x00"})
+	if err == nil {
+		t.Errorf("expected error for null byte in rename, but got none")
+	}
+}
+
+// BenchmarkDuplicateKeys checks that duplicate key policies scale to wide
+// objects, with 10,000 keys that are each repeated once.
+func BenchmarkDuplicateKeys(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString(`{`)
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&sb, `"k%d":%d,`, i%5000, i)
+	}
+	input := sb.String() + `"end":true}`
+	policies := []struct {
+		label  string
+		policy DuplicateKeyPolicy
+	}{
+		{"allow", AllowDuplicateKeys},
+		{"first wins", FirstKeyWins},
+		{"last wins", LastKeyWins},
+	}
+	for _, p := range policies {
+		p := p
+		b.Run(p.label, func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			r := strings.NewReader(input)
+			jib := NewDecoderFromReader(r)
+			jib.DuplicateKeys(p.policy)
+			var buf []byte
+			for i := 0; i < b.N; i++ {
+				r.Reset(input)
+				jib.Reset(r)
+				var err error
+				buf, err = jib.Decode(buf[0:0])
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Lenient toggles lenient parsing of JSON5-style extensions, for input like
+// hand-edited configuration files:
+//
+//   - `//` line comments and `/* */` block comments where white space is
+//     allowed
+//   - trailing commas in objects and arrays
+//   - single-quoted strings and keys, where `\'` is a valid escape
+//   - unquoted keys made of ASCII letters, digits, `_` and `$`, not starting
+//     with a digit
+//   - the numbers `NaN`, `Infinity`, `+Infinity` and `-Infinity`
+//
+// Other JSON5 extensions are not supported.  Parsing is strict by default.
+// Extended JSON is only detected in objects with double-quoted keys.  Error
+// recovery doesn't account for comments or single-quoted strings when skipping
+// a malformed value.
+func (d *Decoder) Lenient(b bool) {
+	d.lenient = b
+}
+
+// skipComment starts after a '/' and consumes a comment.  A line comment ends
+// after a newline or at the end of input.  It returns false without consuming
+// anything if the '/' doesn't start a comment.
+func (d *Decoder) skipComment() (bool, error) {
+	next, err := d.json.Peek(1)
+	if err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+
+	switch next[0] {
+	case '/':
+		_, _ = d.json.Discard(1)
+		for {
+			buf, err := d.json.Peek(64)
+			if len(buf) == 0 {
+				if err == io.EOF {
+					return true, nil
+				}
+				return false, err
+			}
+			if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+				_, _ = d.json.Discard(i + 1)
+				return true, nil
+			}
+			_, _ = d.json.Discard(len(buf))
+		}
+	case '*':
+		_, _ = d.json.Discard(1)
+		for {
+			buf, err := d.json.Peek(64)
+			if len(buf) < 2 {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return false, err
+			}
+			if i := bytes.Index(buf, []byte("*/")); i >= 0 {
+				_, _ = d.json.Discard(i + 2)
+				return true, nil
+			}
+			// Keep a trailing '*' in case the next chunk starts with '/'.
+			_, _ = d.json.Discard(len(buf) - 1)
+		}
+	default:
+		return false, nil
+	}
+}
+
+// endsAfterComma is used in lenient mode after a value separator.  It reports
+// whether the separator is a trailing comma before the given terminator, which
+// it consumes.
+func (d *Decoder) endsAfterComma(end byte) (bool, error) {
+	ch, err := d.readAfterWS()
+	if err != nil {
+		return false, newReadError(err)
+	}
+	if ch == end {
+		return true, nil
+	}
+	_ = d.json.UnreadByte()
+	return false, nil
+}
+
+// convertSingleQuoted starts after the opening quote of a single-quoted string
+// and converts it with a string converter.
+func (d *Decoder) convertSingleQuoted(out []byte, convert func([]byte) ([]byte, error)) ([]byte, error) {
+	d.singleQuoted = true
+	out, err := convert(out)
+	d.singleQuoted = false
+	return out, err
+}
+
+func isIdentifierStart(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_' || ch == '$'
+}
+
+func isIdentifierChar(ch byte) bool {
+	return isIdentifierStart(ch) || (ch >= '0' && ch <= '9')
+}
+
+// convertIdentifier starts at the first character of an unquoted key and
+// converts it to a C string.
+func (d *Decoder) convertIdentifier(out []byte) ([]byte, error) {
+	for {
+		ch, err := d.json.ReadByte()
+		if err != nil {
+			return nil, newReadError(err)
+		}
+		if !isIdentifierChar(ch) {
+			_ = d.json.UnreadByte()
+			break
+		}
+		out = append(out, ch)
+	}
+	out = append(out, nullByte)
+	return out, nil
+}
+
+// convertNonFinite starts at the first character of `NaN` or `Infinity`, with
+// an optional sign for `Infinity`, and converts it to a double.
+func (d *Decoder) convertNonFinite(out []byte, typeBytePos int) ([]byte, error) {
+	buf, err := d.json.Peek(9)
+	if err != nil && err != io.EOF {
+		return nil, newReadError(err)
+	}
+
+	var f float64
+	var n int
+	switch {
+	case bytes.HasPrefix(buf, []byte("NaN")):
+		f, n = math.NaN(), 3
+	case bytes.HasPrefix(buf, []byte("Infinity")):
+		f, n = math.Inf(1), 8
+	case bytes.HasPrefix(buf, []byte("+Infinity")):
+		f, n = math.Inf(1), 9
+	case bytes.HasPrefix(buf, []byte("-Infinity")):
+		f, n = math.Inf(-1), 9
+	default:
+		return nil, d.parseError(nil, "invalid literal")
+	}
+	_, _ = d.json.Discard(n)
+
+	overwriteTypeByte(out, typeBytePos, bsonDouble)
+	var x [8]byte
+	xs := x[0:8]
+	binary.LittleEndian.PutUint64(xs, math.Float64bits(f))
+	out = append(out, xs...)
+	return out, nil
+}
@@ -0,0 +1,223 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestLenient checks JSON5-style extensions in lenient mode and that strict
+// mode rejects them.
+func TestLenient(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label  string
+		input  string
+		output string
+		errStr string
+	}
+
+	cases := []testCase{
+		{
+			label:  "line comments",
+			input:  "// config\n{\"a\": 1, // one\n\"b\": 2 // two\n} // end",
+			output: `{"a":1,"b":2}`,
+		},
+		{
+			label:  "block comments",
+			input:  `/* start */ {/**/"a"/* key */:/* value */[1/*x*/,/* y */2]/***/} /* end **/`,
+			output: `{"a":[1,2]}`,
+		},
+		{
+			label:  "comment after literal",
+			input:  `{"a":true/**/,"b":null// c` + "\n}",
+			output: `{"a":true,"b":null}`,
+		},
+		{
+			label:  "trailing commas",
+			input:  `{"a":[1,2,],"b":{"c":3,},}`,
+			output: `{"a":[1,2],"b":{"c":3}}`,
+		},
+		{
+			label:  "trailing comma with comment",
+			input:  "{\"a\":1, // last\n}",
+			output: `{"a":1}`,
+		},
+		{
+			label:  "single quotes",
+			input:  `{'a':'it\'s "quoted"','b':"it's"}`,
+			output: `{"a":"it's \"quoted\"","b":"it's"}`,
+		},
+		{
+			label:  "unquoted keys",
+			input:  `{a:1, $b_2 :2, _c:{d:3}}`,
+			output: `{"a":1,"$b_2":2,"_c":{"d":3}}`,
+		},
+		{
+			label:  "non-finite numbers",
+			input:  `{"a":NaN,"b":Infinity,"c":+Infinity,"d":[-Infinity],"e":-1}`,
+			output: `{"a":{"$numberDouble":"NaN"},"b":{"$numberDouble":"Infinity"},"c":{"$numberDouble":"Infinity"},"d":[{"$numberDouble":"-Infinity"}],"e":-1}`,
+		},
+		{
+			label:  "unterminated block comment",
+			input:  `{"a":1 /* `,
+			errStr: "unexpected EOF",
+		},
+		{
+			label:  "lone slash",
+			input:  `{"a":1 / 2}`,
+			errStr: "expecting value-separator",
+		},
+		{
+			label:  "double trailing comma",
+			input:  `{"a":1,,}`,
+			errStr: "expecting opening quote of key",
+		},
+		{
+			label:  "leading comma",
+			input:  `{"a":[,1]}`,
+			errStr: "invalid character",
+		},
+		{
+			label:  "unquoted key starting with digit",
+			input:  `{1a:1}`,
+			errStr: "expecting key or end of object",
+		},
+		{
+			label:  "invalid literal",
+			input:  `{"a":Inf}`,
+			errStr: "invalid literal",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+
+			// Strict mode rejects all of these.
+			_, err := Unmarshal([]byte(c.input), nil)
+			if err == nil {
+				t.Errorf("expected error in strict mode, but got none")
+			}
+
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(c.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jib.Lenient(true)
+			got, err := jib.Decode(nil)
+			if c.errStr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errStr) {
+					t.Fatalf("expected error with '%s', but got %v", c.errStr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			json, err := MarshalExtJSON(got, nil, RelaxedExtJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(json) != c.output {
+				t.Errorf("expected %s, but got %s", c.output, json)
+			}
+			_, err = jib.Decode(nil)
+			if err != io.EOF {
+				t.Errorf("expected io.EOF after document, but got %v", err)
+			}
+		})
+	}
+}
+
+// TestLenientInvalidLiteral checks the position of an invalid literal and
+// that reporting it leaves the rest of the input intact.
+func TestLenientInvalidLiteral(t *testing.T) {
+	t.Parallel()
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(`{"a":Inf}` + "\n" + `{"b":"after the error"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jib.Lenient(true)
+	jib.Recover(true)
+
+	_, err = jib.Decode(nil)
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected *ParseError, but got %v", err)
+	}
+	if pe.Offset != 5 || pe.Column != 6 {
+		t.Errorf("expected offset 5 and column 6, but got offset %d and column %d", pe.Offset, pe.Column)
+	}
+	if !strings.Contains(err.Error(), "parse error at `Inf}\n{\"b\":...`") {
+		t.Errorf("unexpected error context: %v", err)
+	}
+
+	got, err := jib.Decode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, err := Marshal(got, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(json) != `{"b":"after the error"}` {
+		t.Errorf("expected next document intact, but got %s", json)
+	}
+}
+
+// TestLenientStreamArray checks that skipping values for StreamArray accounts
+// for comments and single-quoted strings.
+func TestLenientStreamArray(t *testing.T) {
+	t.Parallel()
+
+	input := `{
+		// the ']' in comments and strings must not end skipping
+		meta: {note: 'not ] the end', /* } */ list: [1, 2, /* ] */],},
+		skip: 42/* } */,
+		'data': [{a: 1}, {b: 'two'},],
+	}`
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jib.Lenient(true)
+	err = jib.StreamArray("/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := make([]string, 0)
+	for {
+		var buf []byte
+		buf, err = jib.Decode(nil)
+		if err != nil {
+			break
+		}
+		json, err := Marshal(buf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output = append(output, string(json))
+	}
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, but got %v", err)
+	}
+	expect := []string{`{"a":1}`, `{"b":"two"}`}
+	if !reflect.DeepEqual(output, expect) {
+		t.Errorf("expected %q, but got %q", expect, output)
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NumberPolicy determines how a Decoder converts JSON numbers that can't be
+// represented exactly as a BSON int32, int64 or double.
+type NumberPolicy int
+
+// NumberPolicy constants.
+const (
+	// LossyNumbers converts integers that don't fit in an int64 to a double
+	// and decimals to the nearest double, which may lose precision.  This is
+	// the default.
+	LossyNumbers NumberPolicy = iota
+	// BigIntsToDecimal128 converts integers that don't fit in an int64 to a
+	// Decimal128.  Decimals are converted like LossyNumbers.
+	BigIntsToDecimal128
+	// PreciseToDecimal128 converts integers that don't fit in an int64 and
+	// decimals that a double can't represent to a Decimal128.  A decimal can't
+	// be represented if the shortest form of the nearest double isn't the same
+	// number, like 0.10000000000000001, or if it's out of the double's range.
+	PreciseToDecimal128
+	// ErrorOnPrecisionLoss returns a ParseError for numbers that a double
+	// can't represent.  Integers that don't fit in an int64 are converted to
+	// a double if they can be represented exactly.
+	ErrorOnPrecisionLoss
+)
+
+// NumberPolicy sets the policy for numbers that would lose precision when
+// converted to a BSON int32, int64 or double.  Numbers that fit are converted
+// the same with any policy.  If a Decimal128 can't represent a number either,
+// because it has more than 34 significant digits, it is a ParseError.
+func (d *Decoder) NumberPolicy(policy NumberPolicy) {
+	d.numberPolicy = policy
+}
+
+// convertDecimal128 converts the number in the buffer to a Decimal128.  It
+// does not consume any of the input.
+func (d *Decoder) convertDecimal128(out []byte, typeBytePos int, buf []byte) ([]byte, error) {
+	d128, err := primitive.ParseDecimal128(string(buf))
+	if err != nil {
+		return nil, d.parseError(nil, "number can't be represented as Decimal128")
+	}
+	overwriteTypeByte(out, typeBytePos, bsonDecimal128)
+	return appendDecimal128(out, d128), nil
+}
+
+// appendDecimal128 appends a Decimal128 in BSON's little-endian byte order.
+func appendDecimal128(out []byte, d128 primitive.Decimal128) []byte {
+	hi, lo := d128.GetBytes()
+	var x [8]byte
+	xs := x[0:8]
+	binary.LittleEndian.PutUint64(xs, lo)
+	out = append(out, xs...)
+	binary.LittleEndian.PutUint64(xs, hi)
+	out = append(out, xs...)
+	return out
+}
+
+// isExactFloat reports whether a double parsed from the number in the buffer
+// represents that number, i.e. whether the shortest decimal form of the double
+// has the same significant digits and exponent.  An integer is also exact if
+// the double has exactly its value, like 2^70, whose shortest form has fewer
+// digits.
+func isExactFloat(buf []byte, f float64) bool {
+	var x, y [32]byte
+	digits, exp := significantDigits(buf, x[0:0])
+	shortest := strconv.AppendFloat(y[0:0], f, 'e', -1, 64)
+	fDigits, fExp := significantDigits(shortest, shortest[len(shortest):])
+	if exp == fExp && bytes.Equal(digits, fDigits) {
+		return true
+	}
+	if bytes.IndexAny(buf, ".eE") >= 0 {
+		return false
+	}
+
+	// The double was parsed without a range error, so the integer has at
+	// most a few hundred digits.
+	n, ok := new(big.Int).SetString(string(buf), 10)
+	if !ok {
+		return false
+	}
+	_, acc := new(big.Float).SetInt(n).Float64()
+	return acc == big.Exact
+}
+
+// significantDigits appends the digits of a JSON number, without leading or
+// trailing zeros, to a buffer.  It returns the digits and the exponent for the
+// number as 0.DIGITS times 10 to that power.  Zero has no digits.
+func significantDigits(num []byte, digits []byte) ([]byte, int) {
+	if len(num) > 0 && num[0] == '-' {
+		num = num[1:]
+	}
+
+	var exp int
+	if i := bytes.IndexAny(num, "eE"); i >= 0 {
+		// The number was validated when parsed, so this can only fail for an
+		// out-of-range exponent, which is never exact.
+		n, err := strconv.Atoi(string(num[i+1:]))
+		if err != nil {
+			return nil, 0
+		}
+		exp = n
+		num = num[0:i]
+	}
+
+	start := len(digits)
+	point := -1
+	for _, c := range num {
+		if c == '.' {
+			point = len(digits) - start
+			continue
+		}
+		// Skip leading zeros, which moves the decimal point left.
+		if c == '0' && len(digits) == start {
+			if point >= 0 {
+				exp--
+			}
+			continue
+		}
+		digits = append(digits, c)
+	}
+	if point < 0 {
+		point = len(digits) - start
+	}
+	digits = bytes.TrimRight(digits[start:], "0")
+	if len(digits) == 0 {
+		return digits, 0
+	}
+
+	return digits, exp + point
+}
+
+// NumberType determines which BSON types a Decoder uses for JSON numbers.
+type NumberType int
+
+// NumberType constants.
+const (
+	// NumbersByValue converts integers to an int32 if they fit and an int64
+	// otherwise, and decimals to a double.  This is the default.
+	NumbersByValue NumberType = iota
+	// IntsAsInt64 converts all integers to an int64 and decimals to a double.
+	IntsAsInt64
+	// NumbersAsDouble converts all numbers to a double.
+	NumbersAsDouble
+	// NumbersAsDecimal128 converts all numbers to a Decimal128.
+	NumbersAsDecimal128
+)
+
+// NumberType sets the BSON types for JSON numbers.  Numbers that don't fit
+// the chosen type are handled by the NumberPolicy, e.g. an integer too large
+// for an int64.  Extended JSON numbers like `{"$numberInt":"42"}` already have
+// a type, so they aren't affected.
+func (d *Decoder) NumberType(t NumberType) {
+	d.numberType = t
+}
+
+// FieldNumberType sets the BSON types for JSON numbers in a field, overriding
+// the NumberType set for the Decoder.  The field is given as a dotted path of
+// keys from the top-level document, like `order.items.price`.  Arrays are
+// transparent in a path: if the field is an array, or is in objects in an
+// array, the override applies to numbers in the array.  The override doesn't
+// apply to fields nested in the field.
+func (d *Decoder) FieldNumberType(path string, t NumberType) error {
+	keys, err := splitFieldPath(path)
+	if err != nil {
+		return err
+	}
+
+	if d.numberPaths == nil {
+		d.numberPaths = &numberPathNode{}
+	}
+	node := d.numberPaths
+	for _, key := range keys {
+		child := node.children[key]
+		if child == nil {
+			child = &numberPathNode{}
+			if node.children == nil {
+				node.children = make(map[string]*numberPathNode)
+			}
+			node.children[key] = child
+		}
+		node = child
+	}
+	node.numberType = t
+	node.hasType = true
+	return nil
+}
+
+// splitFieldPath splits a dotted field path into keys.
+func splitFieldPath(path string) ([]string, error) {
+	if path == "" {
+		return nil, errors.New("field path must not be empty")
+	}
+	keys := strings.Split(path, ".")
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("invalid field path %q: empty key", path)
+		}
+	}
+	return keys, nil
+}
+
+// numberPathNode is a node in a tree of field paths with number types.
+type numberPathNode struct {
+	children   map[string]*numberPathNode
+	numberType NumberType
+	hasType    bool
+}
+
+// child returns the node for a key below a node, or nil if none exists.
+func (n *numberPathNode) child(key []byte) *numberPathNode {
+	if n == nil {
+		return nil
+	}
+	return n.children[string(key)]
+}
+
+// currentNumberType returns the number type for the value being converted.
+func (d *Decoder) currentNumberType() NumberType {
+	if d.numberNode != nil && d.numberNode.hasType {
+		return d.numberNode.numberType
+	}
+	return d.numberType
+}
@@ -0,0 +1,231 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestNumberPolicy checks conversion of numbers that can lose precision.
+func TestNumberPolicy(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label  string
+		policy NumberPolicy
+		input  string
+		output string
+		errStr string
+	}
+
+	cases := []testCase{
+		// Numbers that fit convert the same with any policy.
+		{"int32", PreciseToDecimal128, `42`, `{"$numberInt":"42"}`, ""},
+		{"int64", ErrorOnPrecisionLoss, `-9223372036854775808`, `{"$numberLong":"-9223372036854775808"}`, ""},
+		{"exact double", PreciseToDecimal128, `0.1`, `{"$numberDouble":"0.1"}`, ""},
+		{"exact double exponent", ErrorOnPrecisionLoss, `-1.25E-3`, `{"$numberDouble":"-0.00125"}`, ""},
+		{"exact double zero", ErrorOnPrecisionLoss, `-0.000e10`, `{"$numberDouble":"-0.0"}`, ""},
+
+		// Integers that don't fit in int64
+		{"big int lossy", LossyNumbers, `12345678901234567890123`, `{"$numberDouble":"1.2345678901234568E+22"}`, ""},
+		{"big int to decimal", BigIntsToDecimal128, `12345678901234567890123`, `{"$numberDecimal":"12345678901234567890123"}`, ""},
+		{"big negative int to decimal", PreciseToDecimal128, `-9223372036854775809`, `{"$numberDecimal":"-9223372036854775809"}`, ""},
+		{"big int error", ErrorOnPrecisionLoss, `12345678901234567890123`, "", "losing precision"},
+		{"big exact int", ErrorOnPrecisionLoss, `100000000000000000000`, `{"$numberDouble":"1E+20"}`, ""},
+		{"big exact power of two", ErrorOnPrecisionLoss, `1180591620717411303424`, `{"$numberDouble":"1.1805916207174113E+21"}`, ""},
+		{"big inexact power of two plus one", ErrorOnPrecisionLoss, `1180591620717411303425`, "", "losing precision"},
+		{"big int too long", BigIntsToDecimal128, `1234567890123456789012345678901234567`, "", "can't be represented as Decimal128"},
+
+		// Decimals that a double can't represent
+		{"precise decimal lossy", LossyNumbers, `0.10000000000000001`, `{"$numberDouble":"0.1"}`, ""},
+		{"precise decimal with ints only", BigIntsToDecimal128, `0.10000000000000001`, `{"$numberDouble":"0.1"}`, ""},
+		{"precise decimal", PreciseToDecimal128, `0.10000000000000001`, `{"$numberDecimal":"0.10000000000000001"}`, ""},
+		{"precise decimal exponent", PreciseToDecimal128, `9007199254740993.0`, `{"$numberDecimal":"9007199254740993.0"}`, ""},
+		{"decimal out of range", PreciseToDecimal128, `1.5e400`, `{"$numberDecimal":"1.5E+400"}`, ""},
+		{"decimal out of range error", ErrorOnPrecisionLoss, `1.5e400`, "", "value out of range"},
+		{"precise decimal error", ErrorOnPrecisionLoss, `3.14159265358979323846`, "", "losing precision"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+			input := `{"a":` + c.input + `}`
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jib.NumberPolicy(c.policy)
+			got, err := jib.Decode(nil)
+			if c.errStr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errStr) {
+					t.Fatalf("expected error with '%s', but got %v", c.errStr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			json, err := MarshalExtJSON(got, nil, CanonicalExtJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect := `{"a":` + c.output + `}`
+			if string(json) != expect {
+				t.Errorf("expected %s, but got %s", expect, json)
+			}
+		})
+	}
+}
+
+// TestIsExactFloat checks detection of decimals that a double can't represent.
+func TestIsExactFloat(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		num   string
+		exact bool
+	}{
+		{"0", true},
+		{"-0.0e-5", true},
+		{"1", true},
+		{"120", true},
+		{"0.00123", true},
+		{"100.5", true},
+		{"1.5e3", true},
+		{"1.5E+3", true},
+		{"1500e-3", true},
+		{"5e-324", true},
+		{"1.7976931348623157e308", true},
+		{"9007199254740992", true},
+		{"9007199254740993", false},
+		{"1180591620717411303424", true},
+		{"-1180591620717411303424", true},
+		{"1180591620717411303425", false},
+		{"0.10000000000000001", false},
+		{"3.141592653589793", true},
+		{"3.1415926535897932", false},
+		{"4.9e-325", false},
+	}
+
+	for _, c := range cases {
+		f, err := strconv.ParseFloat(c.num, 64)
+		if err != nil {
+			t.Fatalf("%s: %v", c.num, err)
+		}
+		if got := isExactFloat([]byte(c.num), f); got != c.exact {
+			t.Errorf("%s: expected %v, but got %v", c.num, c.exact, got)
+		}
+	}
+}
+
+// TestNumberType checks number type mapping for the decoder and for fields.
+func TestNumberType(t *testing.T) {
+	t.Parallel()
+
+	input := `{"a":1,"b":2.5,"c":[3,{"a":4}],"d":{"a":5,"b":[6,7.5]},"e":{"$numberInt":"8"}}`
+
+	type testCase struct {
+		label      string
+		numberType NumberType
+		fields     map[string]NumberType
+		output     string
+	}
+
+	cases := []testCase{
+		{
+			label:      "by value",
+			numberType: NumbersByValue,
+			output:     `{"a":{"$numberInt":"1"},"b":{"$numberDouble":"2.5"},"c":[{"$numberInt":"3"},{"a":{"$numberInt":"4"}}],"d":{"a":{"$numberInt":"5"},"b":[{"$numberInt":"6"},{"$numberDouble":"7.5"}]},"e":{"$numberInt":"8"}}`,
+		},
+		{
+			label:      "ints as int64",
+			numberType: IntsAsInt64,
+			output:     `{"a":{"$numberLong":"1"},"b":{"$numberDouble":"2.5"},"c":[{"$numberLong":"3"},{"a":{"$numberLong":"4"}}],"d":{"a":{"$numberLong":"5"},"b":[{"$numberLong":"6"},{"$numberDouble":"7.5"}]},"e":{"$numberInt":"8"}}`,
+		},
+		{
+			label:      "as double",
+			numberType: NumbersAsDouble,
+			output:     `{"a":{"$numberDouble":"1.0"},"b":{"$numberDouble":"2.5"},"c":[{"$numberDouble":"3.0"},{"a":{"$numberDouble":"4.0"}}],"d":{"a":{"$numberDouble":"5.0"},"b":[{"$numberDouble":"6.0"},{"$numberDouble":"7.5"}]},"e":{"$numberInt":"8"}}`,
+		},
+		{
+			label:      "as decimal",
+			numberType: NumbersAsDecimal128,
+			output:     `{"a":{"$numberDecimal":"1"},"b":{"$numberDecimal":"2.5"},"c":[{"$numberDecimal":"3"},{"a":{"$numberDecimal":"4"}}],"d":{"a":{"$numberDecimal":"5"},"b":[{"$numberDecimal":"6"},{"$numberDecimal":"7.5"}]},"e":{"$numberInt":"8"}}`,
+		},
+		{
+			label:      "field overrides",
+			numberType: IntsAsInt64,
+			fields: map[string]NumberType{
+				"b":   NumbersAsDecimal128,
+				"c.a": NumbersAsDouble,
+				"d.b": NumbersByValue,
+				"x.y": NumbersAsDouble,
+			},
+			output: `{"a":{"$numberLong":"1"},"b":{"$numberDecimal":"2.5"},"c":[{"$numberLong":"3"},{"a":{"$numberDouble":"4.0"}}],"d":{"a":{"$numberLong":"5"},"b":[{"$numberInt":"6"},{"$numberDouble":"7.5"}]},"e":{"$numberInt":"8"}}`,
+		},
+		{
+			label: "array field override",
+			fields: map[string]NumberType{
+				"c": NumbersAsDouble,
+				"d": NumbersAsDecimal128,
+			},
+			output: `{"a":{"$numberInt":"1"},"b":{"$numberDouble":"2.5"},"c":[{"$numberDouble":"3.0"},{"a":{"$numberInt":"4"}}],"d":{"a":{"$numberInt":"5"},"b":[{"$numberInt":"6"},{"$numberDouble":"7.5"}]},"e":{"$numberInt":"8"}}`,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jib.ExtJSON(true)
+			jib.NumberType(c.numberType)
+			for path, numberType := range c.fields {
+				err = jib.FieldNumberType(path, numberType)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := jib.Decode(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			json, err := MarshalExtJSON(got, nil, CanonicalExtJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(json) != c.output {
+				t.Errorf("expected:\n%s\nbut got:\n%s", c.output, json)
+			}
+		})
+	}
+}
+
+// TestFieldNumberTypeErrors checks validation of field paths.
+func TestFieldNumberTypeErrors(t *testing.T) {
+	t.Parallel()
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(`{}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"", ".a", "a..b", "a."} {
+		if err := jib.FieldNumberType(path, NumbersAsDouble); err == nil {
+			t.Errorf("expected error for path %q", path)
+		}
+	}
+	if jib.numberPaths != nil {
+		t.Error("invalid paths should not add overrides")
+	}
+}
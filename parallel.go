@@ -0,0 +1,357 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// ParallelDecoder converts newline-delimited JSON (NDJSON) to BSON with a pool
+// of Decoders on separate goroutines.  It splits the input into chunks of
+// whole lines, converts the chunks concurrently and delivers the documents to
+// a callback, in input order unless Unordered is set.
+//
+// Each Decoder uses NDJSONFraming, so each line must have exactly one
+// object, and error recovery, so an error in one document is reported for
+// that document and decoding continues with the next line.
+type ParallelDecoder struct {
+	r         io.Reader
+	workers   int
+	chunkSize int
+	unordered bool
+	configure func(*Decoder)
+	chunks    sync.Pool
+}
+
+// ParallelResult is a converted document or an error from a ParallelDecoder.
+type ParallelResult struct {
+	// Document is the 0-based index of the document in the input.  With
+	// NDJSON, it is also the 0-based index of the line.
+	Document int
+	// BSON is the converted document.  It is only valid until the callback
+	// returns.
+	BSON []byte
+	// Err is the error converting the document, if any.  The Offset, Line and
+	// Document of a ParseError, and the Offset and Document of a
+	// DocumentSizeError, are relative to the whole input.
+	Err error
+}
+
+// defaultChunkSize is the default size of the chunks of input converted by
+// each goroutine of a ParallelDecoder.
+const defaultChunkSize = 1 << 20
+
+// NewParallelDecoder returns a new parallel decoder for an input stream,
+// with a number of worker goroutines.  If workers is less than one, it uses
+// runtime.GOMAXPROCS(0).
+func NewParallelDecoder(r io.Reader, workers int) *ParallelDecoder {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &ParallelDecoder{
+		r:         r,
+		workers:   workers,
+		chunkSize: defaultChunkSize,
+		chunks: sync.Pool{
+			New: func() interface{} { return &parallelChunk{} },
+		},
+	}
+}
+
+// ChunkSize sets the approximate size in bytes of the chunks of input that
+// are converted by one goroutine at a time.  Chunks end at a newline, so a
+// chunk is larger if a line doesn't end within the size.  The default is
+// 1 MiB.  A size less than one restores the default.
+func (p *ParallelDecoder) ChunkSize(n int) {
+	if n < 1 {
+		n = defaultChunkSize
+	}
+	p.chunkSize = n
+}
+
+// Unordered toggles delivery of documents as soon as their chunk is
+// converted, instead of in input order.  Documents from the same chunk are
+// still delivered in order.
+func (p *ParallelDecoder) Unordered(b bool) {
+	p.unordered = b
+}
+
+// Configure sets a function to set options on each Decoder, like ExtJSON or
+// MaxDepth.  After it is called, the Decoder's framing is set to NDJSONFraming
+// and its error recovery is turned on, replacing any Framing or Recover that
+// the function set.  StreamArray can't be combined with the framing, so if the
+// function sets it, Decode returns an error.
+func (p *ParallelDecoder) Configure(fn func(*Decoder)) {
+	p.configure = fn
+}
+
+// parallelChunk is a chunk of input and the documents converted from it.
+type parallelChunk struct {
+	input  []byte
+	base   int
+	offset int64
+	output []byte
+	docs   []parallelDoc
+	done   chan struct{}
+}
+
+// parallelDoc locates a converted document in the output of a chunk.
+type parallelDoc struct {
+	index int
+	start int
+	end   int
+	err   error
+}
+
+// Decode reads and converts the whole input, calling a function with each
+// document or error.  The function is called from the goroutine that calls
+// Decode, never concurrently.  If the function returns an error, decoding
+// stops and Decode returns that error.  Otherwise, Decode returns any error
+// reading the input, after the documents read before it, or nil at the end
+// of the input.
+//
+// When the function returns an error, Decode doesn't wait for a read of the
+// input that is in progress, like from a network stream with no data.  The
+// read finishes in the background and no more input is read after it.  To
+// end such a read, close the input.
+func (p *ParallelDecoder) Decode(fn func(ParallelResult) error) error {
+	decoders := make([]*Decoder, p.workers)
+	for i := range decoders {
+		d := NewDecoderFromReader(nil)
+		if p.configure != nil {
+			p.configure(d)
+		}
+		err := d.Framing(NDJSONFraming)
+		if err != nil {
+			return err
+		}
+		d.Recover(true)
+		decoders[i] = d
+	}
+
+	work := make(chan *parallelChunk, p.workers)
+	results := make(chan *parallelChunk, 2*p.workers)
+	stop := make(chan struct{})
+	readDone := make(chan struct{})
+	var readErr error
+
+	// Chunks are sent to results in input order as they are read, unless
+	// unordered, when they are sent as they are converted.
+	go func() {
+		defer close(readDone)
+		defer close(work)
+		readErr = p.readChunks(work, results, stop)
+		if !p.unordered {
+			close(results)
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for _, d := range decoders {
+		d := d
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			p.convertChunks(d, work, results, stop)
+		}()
+	}
+	if p.unordered {
+		go func() {
+			<-readDone
+			workers.Wait()
+			close(results)
+		}()
+	}
+
+	err := p.deliver(results, fn)
+	if err != nil {
+		// Workers stop after their current chunk; the reader may still be
+		// blocked reading.
+		close(stop)
+		workers.Wait()
+		return err
+	}
+	workers.Wait()
+	return readErr
+}
+
+// convertChunks converts chunks from work with a decoder until there are no
+// more or decoding stops.
+func (p *ParallelDecoder) convertChunks(d *Decoder, work, results chan *parallelChunk, stop chan struct{}) {
+	r := bytes.NewReader(nil)
+	for {
+		var c *parallelChunk
+		var ok bool
+		select {
+		case c, ok = <-work:
+			if !ok {
+				return
+			}
+		case <-stop:
+			return
+		}
+
+		p.convertChunk(d, r, c)
+		if !p.unordered {
+			close(c.done)
+			continue
+		}
+		select {
+		case results <- c:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// deliver calls a function with the documents of each chunk from results,
+// waiting for each chunk to be converted.
+func (p *ParallelDecoder) deliver(results chan *parallelChunk, fn func(ParallelResult) error) error {
+	for c := range results {
+		if !p.unordered {
+			<-c.done
+		}
+		for _, doc := range c.docs {
+			res := ParallelResult{Document: doc.index, Err: doc.err}
+			if doc.err == nil {
+				res.BSON = c.output[doc.start:doc.end]
+			}
+			err := fn(res)
+			if err != nil {
+				return err
+			}
+		}
+		p.chunks.Put(c)
+	}
+	return nil
+}
+
+// readChunks splits the input into chunks ending at a newline and sends them
+// to be converted and delivered.  It returns any error reading the input
+// other than io.EOF.
+func (p *ParallelDecoder) readChunks(work, results chan *parallelChunk, stop chan struct{}) error {
+	var carry []byte
+	var lines int
+	var offset int64
+	var err error
+	for err == nil {
+		c := p.chunks.Get().(*parallelChunk)
+		buf := append(c.input[0:0], carry...)
+
+		// Read until there's a full chunk that can end at a newline.
+		cut := -1
+		searched := 0
+		for err == nil {
+			if len(buf) >= p.chunkSize {
+				if i := bytes.LastIndexByte(buf[searched:], '\n'); i >= 0 {
+					cut = searched + i + 1
+					break
+				}
+				searched = len(buf)
+			}
+			buf, err = readMore(p.r, buf, p.chunkSize)
+		}
+		if cut < 0 {
+			cut = len(buf)
+		}
+		carry = append(carry[0:0], buf[cut:]...)
+		c.input = buf[0:cut]
+		if len(c.input) == 0 {
+			p.chunks.Put(c)
+			break
+		}
+
+		c.base, c.offset = lines, offset
+		lines += bytes.Count(c.input, []byte{'\n'})
+		offset += int64(len(c.input))
+
+		if !p.unordered {
+			c.done = make(chan struct{})
+			select {
+			case results <- c:
+			case <-stop:
+				return nil
+			}
+		}
+		select {
+		case work <- c:
+		case <-stop:
+			return nil
+		}
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// readMore reads more input into a buffer, growing it if it's full.
+func readMore(r io.Reader, buf []byte, size int) ([]byte, error) {
+	if len(buf) == cap(buf) {
+		grown := make([]byte, len(buf), 2*cap(buf)+size)
+		copy(grown, buf)
+		buf = grown
+	}
+	n, err := r.Read(buf[len(buf):cap(buf)])
+	return buf[0 : len(buf)+n], err
+}
+
+// convertChunk converts the documents in a chunk with a decoder.
+func (p *ParallelDecoder) convertChunk(d *Decoder, r *bytes.Reader, c *parallelChunk) {
+	r.Reset(c.input)
+	d.Reset(r)
+	c.output = c.output[0:0]
+	c.docs = c.docs[0:0]
+	for {
+		start := len(c.output)
+		out, err := d.Decode(c.output)
+		if err == io.EOF {
+			return
+		}
+		index := c.base + d.docCount - 1
+		if err == nil {
+			c.output = out
+			c.docs = append(c.docs, parallelDoc{index: index, start: start, end: len(out)})
+			continue
+		}
+
+		c.docs = append(c.docs, parallelDoc{index: index, err: c.adjustError(err)})
+
+		// Errors that recovery doesn't handle, like exceeding the maximum
+		// depth, still only affect their line.
+		var pe *ParseError
+		var se *DocumentSizeError
+		if !errors.As(err, &pe) && !errors.As(err, &se) {
+			_, err = d.skipLine(d.docPos.offset)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// adjustError makes the position of an error relative to the whole input
+// instead of to the chunk.
+func (c *parallelChunk) adjustError(err error) error {
+	var pe *ParseError
+	if errors.As(err, &pe) {
+		pe.Offset += c.offset
+		pe.Line += c.base
+		pe.Document += c.base
+	}
+	var se *DocumentSizeError
+	if errors.As(err, &se) {
+		se.Offset += c.offset
+		se.Document += c.base
+	}
+	return err
+}
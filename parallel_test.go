@@ -0,0 +1,184 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// blockingReader blocks reads until its channel is closed.
+type blockingReader chan struct{}
+
+func (b blockingReader) Read([]byte) (int, error) {
+	<-b
+	return 0, io.EOF
+}
+
+func TestParallelDecoder(t *testing.T) {
+	t.Parallel()
+
+	// Lines with errors are marked with their line number.
+	var input strings.Builder
+	var expect []string
+	for i := 0; i < 500; i++ {
+		switch i % 97 {
+		case 13:
+			input.WriteString(`{"a":[1,}` + "\n")
+			expect = append(expect, fmt.Sprintf("%d: line %d", i, i+1))
+		case 51:
+			input.WriteString("\n")
+			expect = append(expect, fmt.Sprintf("%d: line %d", i, i+1))
+		default:
+			fmt.Fprintf(&input, `{"n":%d,"s":"%s"}`+"\n", i, strings.Repeat("x", i%7))
+			expect = append(expect, fmt.Sprintf(`%d: {"n":%d,"s":"%s"}`, i, i, strings.Repeat("x", i%7)))
+		}
+	}
+
+	for _, unordered := range []bool{false, true} {
+		for _, chunkSize := range []int{1, 100, 0} {
+			unordered, chunkSize := unordered, chunkSize
+			label := fmt.Sprintf("unordered %v, chunk size %d", unordered, chunkSize)
+			t.Run(label, func(t *testing.T) {
+				t.Parallel()
+
+				p := NewParallelDecoder(strings.NewReader(input.String()), 4)
+				p.ChunkSize(chunkSize)
+				p.Unordered(unordered)
+
+				var indexes []int
+				output := make(map[int]string)
+				err := p.Decode(func(res ParallelResult) error {
+					indexes = append(indexes, res.Document)
+					if res.Err != nil {
+						var pe *ParseError
+						if !errors.As(res.Err, &pe) {
+							return fmt.Errorf("expected *ParseError, but got %v", res.Err)
+						}
+						if pe.Document != res.Document {
+							return fmt.Errorf("expected error in document %d, but got %d", res.Document, pe.Document)
+						}
+						output[res.Document] = fmt.Sprintf("%d: line %d", res.Document, pe.Line)
+						return nil
+					}
+					json, err := MarshalExtJSON(res.BSON, nil, RelaxedExtJSON)
+					if err != nil {
+						return err
+					}
+					output[res.Document] = fmt.Sprintf("%d: %s", res.Document, json)
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !unordered && !sort.IntsAreSorted(indexes) {
+					t.Errorf("expected documents in input order")
+				}
+				got := make([]string, 0, len(output))
+				for i := 0; i < len(output); i++ {
+					got = append(got, output[i])
+				}
+				if !reflect.DeepEqual(got, expect) {
+					t.Errorf("expected %q, but got %q", expect, got)
+				}
+			})
+		}
+	}
+}
+
+func TestParallelDecoderErrors(t *testing.T) {
+	t.Parallel()
+
+	input := strings.Repeat("{\"a\":1}\n", 100)
+
+	// Decoder options are set for each worker.
+	p := NewParallelDecoder(strings.NewReader("{\"a\":{\"b\":{}}}\n{\"a\":2}\n{\"a\":"), 2)
+	p.Configure(func(d *Decoder) { d.MaxDepth(2) })
+	var errStrs []string
+	var count int
+	err := p.Decode(func(res ParallelResult) error {
+		if res.Err != nil {
+			errStrs = append(errStrs, res.Err.Error())
+		} else {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || len(errStrs) != 2 {
+		t.Fatalf("expected 1 document and 2 errors, but got %d and %q", count, errStrs)
+	}
+	for i, s := range []string{"maximum depth exceeded", "unexpected EOF"} {
+		if !strings.Contains(errStrs[i], s) {
+			t.Errorf("expected error with '%s', but got %s", s, errStrs[i])
+		}
+	}
+
+	// Options can't conflict with the framing.
+	p = NewParallelDecoder(strings.NewReader(input), 2)
+	p.Configure(func(d *Decoder) { _ = d.StreamArray("/data") })
+	err = p.Decode(func(ParallelResult) error { return nil })
+	if err == nil {
+		t.Errorf("expected error combining StreamArray with NDJSON, but got none")
+	}
+
+	// A callback error stops decoding.
+	for _, unordered := range []bool{false, true} {
+		stop := errors.New("stop")
+		p = NewParallelDecoder(strings.NewReader(input), 4)
+		p.ChunkSize(10)
+		p.Unordered(unordered)
+		count = 0
+		err = p.Decode(func(ParallelResult) error {
+			count++
+			if count == 5 {
+				return stop
+			}
+			return nil
+		})
+		if err != stop || count != 5 {
+			t.Errorf("expected stop after 5 documents, but got %v after %d", err, count)
+		}
+	}
+
+	// A callback error doesn't wait for a blocked read.
+	for _, unordered := range []bool{false, true} {
+		stop := errors.New("stop")
+		unblock := make(chan struct{})
+		p = NewParallelDecoder(io.MultiReader(strings.NewReader(input), blockingReader(unblock)), 4)
+		p.ChunkSize(10)
+		p.Unordered(unordered)
+		err = p.Decode(func(ParallelResult) error { return stop })
+		close(unblock)
+		if err != stop {
+			t.Errorf("expected stop with a blocked read, but got %v", err)
+		}
+	}
+
+	// A read error is returned after the documents read before it.
+	p = NewParallelDecoder(io.MultiReader(strings.NewReader(input), errorReader{}), 4)
+	p.ChunkSize(10)
+	count = 0
+	err = p.Decode(func(res ParallelResult) error {
+		if res.Err != nil {
+			return res.Err
+		}
+		count++
+		return nil
+	})
+	if !errors.Is(err, errTestRead) || count != 100 {
+		t.Errorf("expected read error after 100 documents, but got %v after %d", err, count)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StreamArray sets a JSON Pointer (RFC 6901) to an array nested in the first
+// top-level value of the input, e.g. `/data` for `{"meta":{},"data":[...]}`.
+// Decode then streams the values of that array instead of top-level values.
+// Content before the array is skipped without being converted and content
+// after it is not read.  An empty path restores streaming top-level values.
+//
+// It must be called before the first call to Decode or DecodeValue.  If the
+// array can't be found, the first call returns an error and the stream ends.
+func (d *Decoder) StreamArray(path string) error {
+	if d.docCount > 0 || d.arrayFinished {
+		return errors.New("StreamArray must be called before decoding")
+	}
+	if d.framing != AutoFraming && path != "" {
+		return errors.New("StreamArray can't be combined with Framing")
+	}
+	if path == "" {
+		d.arrayPath = nil
+		return nil
+	}
+	if path[0] != '/' {
+		return fmt.Errorf("invalid JSON Pointer %q: must start with '/'", path)
+	}
+
+	tokens := strings.Split(path[1:], "/")
+	d.arrayPath = make([][]byte, len(tokens))
+	for i, token := range tokens {
+		token = strings.Replace(token, "~1", "/", -1)
+		token = strings.Replace(token, "~0", "~", -1)
+		d.arrayPath[i] = []byte(token)
+	}
+
+	return nil
+}
+
+// findArray consumes input up to and including the opening bracket of the
+// array selected by StreamArray.
+func (d *Decoder) findArray() error {
+	// If NewDecoder found a top-level array, its bracket was consumed.
+	var ch byte
+	var err error
+	if d.arrayStarted {
+		ch = '['
+		d.arrayStarted = false
+	} else {
+		ch, err = d.readAfterWS()
+		if err != nil {
+			return newReadError(err)
+		}
+	}
+
+	// Containers on the path count toward the depth limit while skipping
+	// their contents.
+	defer func() { d.curDepth = 0 }()
+	for _, token := range d.arrayPath {
+		d.curDepth++
+		if d.curDepth > d.maxDepth {
+			return errors.New("maximum depth exceeded")
+		}
+		switch ch {
+		case '{':
+			err = d.findObjectKey(token)
+		case '[':
+			err = d.findArrayIndex(token)
+		default:
+			return d.parseError([]byte{ch}, fmt.Sprintf("no object or array for path token %q", token))
+		}
+		if err != nil {
+			return err
+		}
+		ch, err = d.readAfterWS()
+		if err != nil {
+			return newReadError(err)
+		}
+	}
+
+	if ch != '[' {
+		return d.parseError([]byte{ch}, "path doesn't select an array")
+	}
+	d.arrayStarted = true
+
+	return nil
+}
+
+// findObjectKey starts after the opening brace of an object.  It skips
+// elements up to the one with the given key and consumes the key and name
+// separator.
+func (d *Decoder) findObjectKey(key []byte) error {
+	scratchP := d.scratchPool.Get().(*[]byte)
+	defer func() { d.scratchPool.Put(scratchP) }()
+
+	ch, err := d.readAfterWS()
+	if err != nil {
+		return newReadError(err)
+	}
+	for {
+		if ch == '}' {
+			return d.parseError([]byte{ch}, fmt.Sprintf("path key %q not found", key))
+		}
+		*scratchP, err = d.convertKey((*scratchP)[0:0], ch)
+		if err != nil && err != errNullEscape {
+			return err
+		}
+		err = d.readNameSeparator()
+		if err != nil {
+			return err
+		}
+
+		// Compare without the null terminator.
+		if bytes.Equal((*scratchP)[0:len(*scratchP)-1], key) {
+			return nil
+		}
+
+		err = d.skipValue()
+		if err != nil {
+			return err
+		}
+		ch, err = d.readAfterWS()
+		if err != nil {
+			return newReadError(err)
+		}
+		switch ch {
+		case ',':
+			ch, err = d.readAfterWS()
+			if err != nil {
+				return newReadError(err)
+			}
+		case '}':
+		default:
+			return d.parseError([]byte{ch}, "expecting value-separator or end of object")
+		}
+	}
+}
+
+// findArrayIndex starts after the opening bracket of an array.  It skips
+// values up to the one at the given index.  Like RFC 6901, the index must be
+// decimal digits without a sign or leading zeros.
+func (d *Decoder) findArrayIndex(token []byte) error {
+	index, err := strconv.Atoi(string(token))
+	if err != nil || !isArrayIndex(token) {
+		return d.parseError(nil, fmt.Sprintf("path token %q is not an array index", token))
+	}
+
+	for i := 0; i < index; i++ {
+		ch, err := d.readAfterWS()
+		if err != nil {
+			return newReadError(err)
+		}
+		if ch == ']' {
+			return d.parseError([]byte{ch}, fmt.Sprintf("path index %d not found", index))
+		}
+		_ = d.json.UnreadByte()
+
+		err = d.skipValue()
+		if err != nil {
+			return err
+		}
+		ch, err = d.readAfterWS()
+		if err != nil {
+			return newReadError(err)
+		}
+		switch ch {
+		case ',':
+		case ']':
+			return d.parseError([]byte{ch}, fmt.Sprintf("path index %d not found", index))
+		default:
+			return d.parseError([]byte{ch}, "expecting value-separator or end of array")
+		}
+	}
+
+	return nil
+}
+
+// isArrayIndex returns true if a path token is "0" or digits that don't start
+// with zero.
+func isArrayIndex(token []byte) bool {
+	if len(token) == 0 || (token[0] == '0' && len(token) > 1) {
+		return false
+	}
+	for _, c := range token {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,184 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestStreamArray checks streaming objects from arrays nested in the input.
+func TestStreamArray(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label  string
+		input  string
+		path   string
+		output []string
+		errStr string
+	}
+
+	cases := []testCase{
+		{
+			label:  "wrapped payload",
+			input:  `{"meta":{"next":"a,]}\"b","pages":[1,[2,{}]]},"count":2,"data":[{"a":1},{"a":2}],"extra":{}}`,
+			path:   "/data",
+			output: []string{`{"a":1}`, `{"a":2}`},
+			errStr: io.EOF.Error(),
+		},
+		{
+			label:  "GeoJSON",
+			input:  `{ "type" : "FeatureCollection", "features" : [ { "type" : "Feature" } ] }`,
+			path:   "/features",
+			output: []string{`{"type":"Feature"}`},
+			errStr: io.EOF.Error(),
+		},
+		{
+			label:  "nested with indexes",
+			input:  `[{"a":[]}, {"a":[null, {"b~/c":[{"x":true},{"x":false}]}]}]`,
+			path:   "/1/a/1/b~0~1c",
+			output: []string{`{"x":true}`, `{"x":false}`},
+			errStr: io.EOF.Error(),
+		},
+		{
+			label:  "empty array",
+			input:  `{"data":[]}`,
+			path:   "/data",
+			output: []string{},
+			errStr: io.EOF.Error(),
+		},
+		{
+			label:  "escaped key",
+			input:  `{"d\u0061ta":[{}]}`,
+			path:   "/data",
+			output: []string{`{}`},
+			errStr: io.EOF.Error(),
+		},
+		{
+			label:  "key not found",
+			input:  `{"meta":{"data":[]}}`,
+			path:   "/data",
+			output: []string{},
+			errStr: `path key "data" not found`,
+		},
+		{
+			label:  "index not found",
+			input:  `[[], []]`,
+			path:   "/2",
+			output: []string{},
+			errStr: "path index 2 not found",
+		},
+		{
+			label:  "index with sign",
+			input:  `[[], []]`,
+			path:   "/+1",
+			output: []string{},
+			errStr: `path token "+1" is not an array index`,
+		},
+		{
+			label:  "index with leading zero",
+			input:  `[[], []]`,
+			path:   "/01",
+			output: []string{},
+			errStr: `path token "01" is not an array index`,
+		},
+		{
+			label:  "not an array",
+			input:  `{"data":{}}`,
+			path:   "/data",
+			output: []string{},
+			errStr: "path doesn't select an array",
+		},
+		{
+			label:  "not a container",
+			input:  `{"data":42}`,
+			path:   "/data/0",
+			output: []string{},
+			errStr: "no object or array for path token",
+		},
+		{
+			label:  "mismatched skipped value",
+			input:  `{"meta":{"a":[}, "data":[]}`,
+			path:   "/data",
+			output: []string{},
+			errStr: "mismatched end of object or array",
+		},
+		{
+			label:  "unterminated skipped value",
+			input:  `{"meta":"abc`,
+			path:   "/data",
+			output: []string{},
+			errStr: "unexpected EOF",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(c.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = jib.StreamArray(c.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output := make([]string, 0)
+			for {
+				var buf []byte
+				buf, err = jib.Decode(nil)
+				if err != nil {
+					break
+				}
+				json, err := Marshal(buf, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				output = append(output, string(json))
+			}
+
+			if !strings.Contains(err.Error(), c.errStr) {
+				t.Errorf("expected error with '%s', but got %v", c.errStr, err)
+			}
+			if !reflect.DeepEqual(output, c.output) {
+				t.Errorf("expected output %q, but got %q", c.output, output)
+			}
+			_, err = jib.Decode(nil)
+			if err != io.EOF {
+				t.Errorf("expected io.EOF after end of stream, but got %v", err)
+			}
+		})
+	}
+}
+
+// TestStreamArrayErrors checks validation of StreamArray arguments.
+func TestStreamArrayErrors(t *testing.T) {
+	t.Parallel()
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(`{} {}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = jib.StreamArray("data")
+	if err == nil {
+		t.Error("expected error for path without leading '/'")
+	}
+	_, err = jib.Decode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = jib.StreamArray("/data")
+	if err == nil {
+		t.Error("expected error for StreamArray after Decode")
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// position is a position in the input stream, with the number of newlines
+// before it and the offset of the last one, from which its line and column
+// follow.
+type position struct {
+	offset int64
+	lines  int
+	lastNL int64
+}
+
+// lineColumn returns the 1-based line and byte column of the position.
+func (p position) lineColumn() (int, int) {
+	return p.lines + 1, int(p.offset - p.lastNL)
+}
+
+// inputReader is the bufio.Reader a Decoder reads from.  The offset of the
+// next byte follows from the window of input the reader has buffered, so
+// reading needs no copy of the input.  Newlines are only counted in bulk,
+// before the window is refilled or when a position is needed.  Only the
+// methods the Decoder uses are provided, so that no input is missed.
+type inputReader struct {
+	r *bufio.Reader
+	// window is the input that was buffered when the reader was last
+	// filled, starting at windowStart.  Newlines in it are counted up to
+	// counted.
+	window      []byte
+	windowStart int64
+	counted     int
+	// lines and lastNL are as in position, and prevNL is the offset of the
+	// newline before lastNL, for positions before the last one consumed.
+	// winLastNL and winPrevNL are their values at the start of the window.
+	lines     int
+	lastNL    int64
+	prevNL    int64
+	winLastNL int64
+	winPrevNL int64
+	// firstNL is the offset of the first newline at or after mark, or less
+	// than mark if there is none yet.
+	mark    int64
+	firstNL int64
+}
+
+// reset makes the reader read from a new input, from offset zero.
+func (r *inputReader) reset(br *bufio.Reader) {
+	*r = inputReader{r: br, lastNL: -1, prevNL: -1, firstNL: -1}
+	r.moveTo(br, 0)
+}
+
+// moveTo makes the reader continue from an offset with a bufio.Reader, with
+// the input it has buffered as the window.  Any newlines before the offset
+// must have been counted.
+func (r *inputReader) moveTo(br *bufio.Reader, offset int64) {
+	r.r = br
+	r.window, _ = br.Peek(br.Buffered())
+	r.windowStart = offset
+	r.counted = 0
+	r.winLastNL = r.lastNL
+	r.winPrevNL = r.prevNL
+}
+
+// offset returns the offset of the next byte to be read.
+func (r *inputReader) offset() int64 {
+	return r.windowStart + int64(len(r.window)-r.r.Buffered())
+}
+
+// position returns the position of the next byte to be read.
+func (r *inputReader) position() position {
+	r.count()
+	return position{offset: r.offset(), lines: r.lines, lastNL: r.lastNL}
+}
+
+// before returns the position before the bytes most recently consumed, which
+// must have at most one newline.
+func (r *inputReader) before(consumed []byte) position {
+	p := r.position()
+	p.offset -= int64(len(consumed))
+	if p.lastNL >= p.offset {
+		p.lines--
+		p.lastNL = r.prevNL
+	}
+	return p
+}
+
+// count counts the newlines consumed from the window since the last count,
+// or uncounts those that were read again after UnreadByte.
+func (r *inputReader) count() {
+	consumed := len(r.window) - r.r.Buffered()
+	if consumed >= r.counted {
+		r.countNewlines(r.window[r.counted:consumed], r.windowStart+int64(r.counted))
+		r.counted = consumed
+		return
+	}
+
+	r.lines -= bytes.Count(r.window[consumed:r.counted], []byte{'\n'})
+	r.counted = consumed
+	offset := r.windowStart + int64(consumed)
+	if r.lastNL >= offset {
+		r.lastNL, r.prevNL = r.winLastNL, r.winPrevNL
+		if i := bytes.LastIndexByte(r.window[0:consumed], '\n'); i >= 0 {
+			r.prevNL = r.winLastNL
+			if j := bytes.LastIndexByte(r.window[0:i], '\n'); j >= 0 {
+				r.prevNL = r.windowStart + int64(j)
+			}
+			r.lastNL = r.windowStart + int64(i)
+		}
+	}
+	if r.firstNL >= offset {
+		r.firstNL = -1
+	}
+}
+
+// uncount moves the count of newlines back over consumed bytes, to a
+// position after the bytes kept that follow an earlier position.
+func (r *inputReader) uncount(consumed []byte, from position, kept []byte) {
+	r.count()
+	offset := from.offset + int64(len(kept))
+	r.lines -= bytes.Count(consumed, []byte{'\n'})
+	if r.lastNL >= offset {
+		r.lastNL, r.prevNL = from.lastNL, -1
+		if i := bytes.LastIndexByte(kept, '\n'); i >= 0 {
+			r.prevNL = from.lastNL
+			if j := bytes.LastIndexByte(kept[0:i], '\n'); j >= 0 {
+				r.prevNL = from.offset + int64(j)
+			}
+			r.lastNL = from.offset + int64(i)
+		}
+	}
+	if r.firstNL >= offset {
+		r.firstNL = -1
+	}
+}
+
+// countNewlines counts the newlines in consumed input starting at an offset.
+func (r *inputReader) countNewlines(b []byte, start int64) {
+	i := bytes.LastIndexByte(b, '\n')
+	if i < 0 {
+		return
+	}
+	r.lines += bytes.Count(b, []byte{'\n'})
+	r.prevNL = r.lastNL
+	if j := bytes.LastIndexByte(b[0:i], '\n'); j >= 0 {
+		r.prevNL = start + int64(j)
+	}
+	r.lastNL = start + int64(i)
+	if r.firstNL < r.mark {
+		r.firstNL = start + int64(bytes.IndexByte(b, '\n'))
+	}
+}
+
+// fill counts the newlines consumed from the window and then peeks at the
+// next n bytes, which may refill the buffer.
+func (r *inputReader) fill(n int) ([]byte, error) {
+	r.count()
+	offset := r.offset()
+	b, err := r.r.Peek(n)
+	r.moveTo(r.r, offset)
+	return b, err
+}
+
+func (r *inputReader) ReadByte() (byte, error) {
+	if r.r.Buffered() == 0 {
+		_, _ = r.fill(1)
+	}
+	return r.r.ReadByte()
+}
+
+func (r *inputReader) UnreadByte() error {
+	return r.r.UnreadByte()
+}
+
+func (r *inputReader) Discard(n int) (int, error) {
+	if n <= r.r.Buffered() {
+		return r.r.Discard(n)
+	}
+
+	var discarded int
+	for discarded < n {
+		if r.r.Buffered() == 0 {
+			_, err := r.fill(1)
+			if err != nil {
+				return discarded, err
+			}
+		}
+		k := n - discarded
+		if k > r.r.Buffered() {
+			k = r.r.Buffered()
+		}
+		_, _ = r.r.Discard(k)
+		discarded += k
+	}
+	return discarded, nil
+}
+
+func (r *inputReader) ReadSlice(delim byte) ([]byte, error) {
+	// ReadSlice may refill the buffer, so the line is counted as it's read.
+	r.count()
+	offset := r.offset()
+	line, err := r.r.ReadSlice(delim)
+	r.countNewlines(line, offset)
+	r.moveTo(r.r, offset+int64(len(line)))
+	return line, err
+}
+
+func (r *inputReader) Peek(n int) ([]byte, error) {
+	if n <= r.r.Buffered() {
+		return r.r.Peek(n)
+	}
+	return r.fill(n)
+}
+
+func (r *inputReader) Buffered() int {
+	return r.r.Buffered()
+}
+
+// captureReader reads the input for a Decoder that needs a copy of the
+// current value, like for error recovery, so that it can report the text of a
+// malformed value or rewind the stream to re-read part of it.  It keeps the
+// input read through it while capturing, from where the capture starts.
+type captureReader struct {
+	r            io.Reader
+	offset       int64
+	history      []byte
+	historyStart int64
+	pending      []byte
+	capturing    bool
+}
+
+// reset makes the reader read from a new input, starting at an offset in the
+// input stream.  Capturing stops.
+func (c *captureReader) reset(r io.Reader, offset int64) {
+	c.r = r
+	c.offset = offset
+	c.pending = nil
+	c.stopCapture()
+}
+
+func (c *captureReader) Read(b []byte) (int, error) {
+	var n int
+	var err error
+	if len(c.pending) > 0 {
+		n = copy(b, c.pending)
+		c.pending = c.pending[n:]
+	} else {
+		n, err = c.r.Read(b)
+	}
+
+	c.offset += int64(n)
+	if c.capturing {
+		c.history = append(c.history, b[0:n]...)
+	}
+
+	return n, err
+}
+
+// startCapture begins capturing input from the current offset.
+func (c *captureReader) startCapture() {
+	c.capturing = true
+	c.history = c.history[0:0]
+	c.historyStart = c.offset
+}
+
+// stopCapture stops capturing input and discards what was captured.
+func (c *captureReader) stopCapture() {
+	c.capturing = false
+	c.history = c.history[0:0]
+	c.historyStart = c.offset
+}
+
+// trimCapture discards captured input before an offset.  To amortize
+// copying, it's only dropped once it's at least half of what was captured.
+func (c *captureReader) trimCapture(offset int64) {
+	if drop := int(offset - c.historyStart); drop > 0 && drop >= len(c.history)/2 {
+		c.history = c.history[:copy(c.history, c.history[drop:])]
+		c.historyStart = offset
+	}
+}
+
+// captured returns the captured input between two offsets.
+func (c *captureReader) captured(start, end int64) []byte {
+	return c.history[start-c.historyStart : end-c.historyStart]
+}
+
+// separatorAfter returns the offset of the first record separator at or
+// after a captured offset, or -1 if none has been read.
+func (c *captureReader) separatorAfter(offset int64) int64 {
+	if i := bytes.IndexByte(c.history[offset-c.historyStart:], recordSeparator); i >= 0 {
+		return offset + int64(i)
+	}
+	return -1
+}
+
+// unread moves the reader back by the length of the data, which must be the
+// bytes most recently read.  They will be read again before any further
+// input.
+func (c *captureReader) unread(data []byte) {
+	pending := make([]byte, 0, len(data)+len(c.pending))
+	pending = append(pending, data...)
+	c.pending = append(pending, c.pending...)
+
+	c.offset -= int64(len(data))
+	if keep := c.offset - c.historyStart; keep < 0 {
+		c.history = c.history[0:0]
+		c.historyStart = c.offset
+	} else if keep < int64(len(c.history)) {
+		c.history = c.history[0:keep]
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import "errors"
+
+// ProjectionMode determines whether a Decoder includes or excludes the fields
+// of a projection.
+type ProjectionMode int
+
+// ProjectionMode constants.
+const (
+	// NoProjection converts all fields.  This is the default.
+	NoProjection ProjectionMode = iota
+	// IncludePaths converts only the fields of the projection.
+	IncludePaths
+	// ExcludePaths converts all fields except the fields of the projection.
+	ExcludePaths
+)
+
+// Projection sets fields to include or exclude when converting objects, like
+// a MongoDB projection.  Fields are given as dotted paths of keys from the
+// top-level document, like `order.items.price`, and arrays are transparent in
+// a path, like with FieldNumberType.  A field includes or excludes everything
+// nested in it.
+//
+// When including `a.b`, `a` keeps only `b` if it's an object; an array in
+// `a` keeps only its objects and arrays, each projected the same way; and `a`
+// is dropped if it's any other type.  Unlike MongoDB, `_id` isn't included
+// unless it's given.
+//
+// Skipped fields aren't converted, so they are scanned only to find their end:
+// some malformed JSON in them isn't detected, and they aren't checked for
+// duplicate keys.  Projection applies to the top-level objects from Decode and
+// to any objects in values from DecodeValue.  NoProjection removes a
+// projection and ignores the paths.
+func (d *Decoder) Projection(mode ProjectionMode, paths ...string) error {
+	if mode == NoProjection {
+		d.projectionMode, d.projection = mode, nil
+		return nil
+	}
+	if len(paths) == 0 {
+		return errors.New("projection must have at least one field path")
+	}
+
+	// Validate all paths before replacing the projection.
+	root := &projectionNode{}
+	for _, path := range paths {
+		keys, err := splitFieldPath(path)
+		if err != nil {
+			return err
+		}
+		node := root
+		for _, key := range keys {
+			child := node.children[key]
+			if child == nil {
+				child = &projectionNode{}
+				if node.children == nil {
+					node.children = make(map[string]*projectionNode)
+				}
+				node.children[key] = child
+			}
+			node = child
+		}
+		node.leaf = true
+	}
+
+	d.projectionMode, d.projection = mode, root
+	return nil
+}
+
+// projectionNode is a node in a tree of projected field paths.  A leaf is the
+// end of a path, which includes or excludes everything nested in it.
+type projectionNode struct {
+	children map[string]*projectionNode
+	leaf     bool
+}
+
+// projectField returns the projection node for a field in an object at the
+// current node and whether the field is skipped.  A nil node means the value
+// of the field is converted without projection.
+func (d *Decoder) projectField(key []byte) (*projectionNode, bool) {
+	child := d.projectNode.children[string(key)]
+	if d.projectionMode == IncludePaths {
+		if child == nil {
+			return nil, true
+		}
+		if child.leaf {
+			return nil, false
+		}
+		return child, false
+	}
+	if child == nil {
+		return nil, false
+	}
+	if child.leaf {
+		return nil, true
+	}
+	return child, false
+}
+
+// dropsProjected reports whether a converted value is dropped because it is
+// on the path to an included field but isn't an object or array.
+func (d *Decoder) dropsProjected(out []byte, typeBytePos int) bool {
+	if d.projectNode == nil || d.projectionMode != IncludePaths {
+		return false
+	}
+	t := out[typeBytePos]
+	return t != bsonDocument && t != bsonArray
+}
@@ -0,0 +1,284 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestProjection(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label   string
+		mode    ProjectionMode
+		paths   []string
+		input   string
+		output  string
+		errStr  string
+		errPath string
+	}
+
+	cases := []testCase{
+		{
+			label:  "include top-level fields",
+			mode:   IncludePaths,
+			paths:  []string{"a", "c"},
+			input:  `{"a":1,"b":{"x":[1,2,{"y":3}]},"c":"three","d":null}`,
+			output: `{"a":1,"c":"three"}`,
+		},
+		{
+			label:  "include nested field",
+			mode:   IncludePaths,
+			paths:  []string{"a.b"},
+			input:  `{"a":{"b":{"c":1},"x":2},"y":3}`,
+			output: `{"a":{"b":{"c":1}}}`,
+		},
+		{
+			label:  "include through array",
+			mode:   IncludePaths,
+			paths:  []string{"a.b"},
+			input:  `{"a":[1,{"b":2,"c":3},"x",[{"b":4},5],{"c":6}]}`,
+			output: `{"a":[{"b":2},[{"b":4}],{}]}`,
+		},
+		{
+			label:  "include drops scalar on path",
+			mode:   IncludePaths,
+			paths:  []string{"a.b", "c"},
+			input:  `{"a":5,"c":6}`,
+			output: `{"c":6}`,
+		},
+		{
+			label:  "include drops extended JSON on path",
+			mode:   IncludePaths,
+			paths:  []string{"a.$oid"},
+			input:  `{"a":{"$oid":"5f0c6f2e8f1b2c3d4e5f6a7b"}}`,
+			output: `{}`,
+		},
+		{
+			label:  "include overlapping paths",
+			mode:   IncludePaths,
+			paths:  []string{"a.b", "a"},
+			input:  `{"a":{"b":1,"c":2}}`,
+			output: `{"a":{"b":1,"c":2}}`,
+		},
+		{
+			label:  "exclude fields",
+			mode:   ExcludePaths,
+			paths:  []string{"b", "c.d"},
+			input:  `{"a":1,"b":{"x":[1,{"y":"}]"}]},"c":{"d":2,"e":3},"f":[{"d":4}]}`,
+			output: `{"a":1,"c":{"e":3},"f":[{"d":4}]}`,
+		},
+		{
+			label:  "exclude through array",
+			mode:   ExcludePaths,
+			paths:  []string{"a.b"},
+			input:  `{"a":[1,{"b":2,"c":3}]}`,
+			output: `{"a":[1,{"c":3}]}`,
+		},
+		{
+			label:  "excluded value isn't converted",
+			mode:   ExcludePaths,
+			paths:  []string{"a"},
+			input:  `{"a":{"b":tru},"c":1}`,
+			output: `{"c":1}`,
+		},
+		{
+			label:   "error in excluded value",
+			mode:    ExcludePaths,
+			paths:   []string{"a.b"},
+			input:   `{"a":{"b":[1}}`,
+			errStr:  "mismatched end of object or array",
+			errPath: "/a/b",
+		},
+		{
+			label:   "error in array after dropped element",
+			mode:    IncludePaths,
+			paths:   []string{"a.b"},
+			input:   `{"a":[1,{"b":2},{"b":tru}]}`,
+			errStr:  "expecting true",
+			errPath: "/a/2/b",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(c.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jib.ExtJSON(true)
+			err = jib.Projection(c.mode, c.paths...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := jib.Decode(nil)
+			if c.errStr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errStr) {
+					t.Fatalf("expected error with '%s', but got %v", c.errStr, err)
+				}
+				pe, ok := err.(*ParseError)
+				if !ok {
+					t.Fatalf("expected *ParseError, but got %T", err)
+				}
+				if pe.Path != c.errPath {
+					t.Errorf("expected path %q, but got %q", c.errPath, pe.Path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			json, err := Marshal(got, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(json) != c.output {
+				t.Errorf("expected %s, but got %s", c.output, json)
+			}
+			_, err = jib.Decode(nil)
+			if err != io.EOF {
+				t.Errorf("expected io.EOF after document, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestProjectionErrors(t *testing.T) {
+	t.Parallel()
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(`{"a":1,"b":2}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = jib.Projection(IncludePaths, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, paths := range [][]string{{}, {"b", ""}, {"b..c"}} {
+		err = jib.Projection(ExcludePaths, paths...)
+		if err == nil {
+			t.Errorf("expected error for paths %q, but got none", paths)
+		}
+	}
+
+	// The earlier projection is kept after an error.
+	got, err := jib.Decode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, err := Marshal(got, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(json) != `{"a":1}` {
+		t.Errorf("expected %s, but got %s", `{"a":1}`, json)
+	}
+}
+
+func TestProjectionDuplicateKeys(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label  string
+		policy DuplicateKeyPolicy
+		input  string
+		output string
+		errStr string
+	}
+
+	cases := []testCase{
+		{
+			label:  "allow",
+			policy: AllowDuplicateKeys,
+			input:  `{"a":5,"x":1,"x":2}`,
+			output: `{"x":1,"x":2}`,
+		},
+		{
+			label:  "error after dropped element",
+			policy: ErrorOnDuplicateKeys,
+			input:  `{"a":5,"x":1}`,
+			output: `{"x":1}`,
+		},
+		{
+			label:  "error on dropped key",
+			policy: ErrorOnDuplicateKeys,
+			input:  `{"a":5,"x":1,"a":{"b":2}}`,
+			errStr: `duplicate key "a"`,
+		},
+		{
+			label:  "first wins after dropped element",
+			policy: FirstKeyWins,
+			input:  `{"a":5,"x":1,"x":2}`,
+			output: `{"x":1}`,
+		},
+		{
+			label:  "first wins on dropped key",
+			policy: FirstKeyWins,
+			input:  `{"a":5,"x":1,"a":{"b":2}}`,
+			output: `{"x":1}`,
+		},
+		{
+			label:  "last wins after dropped element",
+			policy: LastKeyWins,
+			input:  `{"a":5,"x":1,"x":2}`,
+			output: `{"x":2}`,
+		},
+		{
+			label:  "last wins on dropped key",
+			policy: LastKeyWins,
+			input:  `{"a":5,"x":1,"a":{"b":2,"c":3}}`,
+			output: `{"x":1,"a":{"b":2}}`,
+		},
+		{
+			label:  "last wins dropping last key",
+			policy: LastKeyWins,
+			input:  `{"a":{"b":2},"x":1,"a":5}`,
+			output: `{"x":1}`,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(c.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = jib.Projection(IncludePaths, "a.b", "x")
+			if err != nil {
+				t.Fatal(err)
+			}
+			jib.DuplicateKeys(c.policy)
+			got, err := jib.Decode(nil)
+			if c.errStr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errStr) {
+					t.Fatalf("expected error with '%s', but got %v", c.errStr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			json, err := Marshal(got, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(json) != c.output {
+				t.Errorf("expected %s, but got %s", c.output, json)
+			}
+		})
+	}
+}
@@ -0,0 +1,436 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxShellNameLength is longer than any supported shell constructor name.
+const maxShellNameLength = 16
+
+// ShellMode toggles parsing of the constructor syntax for BSON types used by
+// the `mongo` shell, as found in shell output and legacy test fixtures:
+//
+//   - `ObjectId("5f0c6f2e8f1b2c3d4e5f6a7b")`
+//   - `ISODate("2020-07-13T12:00:00Z")` and `new Date("2020-07-13T12:00:00Z")`,
+//     with the same ISO-8601 formats as `$date`
+//   - `new Date(1594641600000)`, with milliseconds since the epoch
+//   - `NumberInt(42)` and `NumberLong(42)`, with a number or a quoted number
+//   - `NumberDecimal("1.5")`
+//   - `BinData(4, "base64")`, with a decimal subtype
+//   - `Timestamp(1594641600, 1)`, with seconds and an increment
+//   - `/pattern/flags` regular expressions, with the same flags as
+//     `$regularExpression`
+//
+// Values are converted to the same BSON as their Extended JSON equivalents.
+// String arguments must be double-quoted and can't have escapes.  A regular
+// expression pattern is kept as written, including any escapes.
+func (d *Decoder) ShellMode(b bool) {
+	d.shellMode = b
+}
+
+// peekIdentifier peeks at the identifier that starts the input stream.
+// Nothing is consumed from the input stream.
+func (d *Decoder) peekIdentifier() ([]byte, error) {
+	buf, err := d.json.Peek(maxShellNameLength)
+	if err != nil && err != io.EOF {
+		return nil, newReadError(err)
+	}
+	var i int
+	for i < len(buf) && isIdentifierChar(buf[i]) {
+		i++
+	}
+	return buf[0:i], nil
+}
+
+// convertShellValue starts at the first character of a shell constructor and
+// converts it.  The JSON literal `null`, and `NaN` and `Infinity` in lenient
+// mode, are converted as usual.
+func (d *Decoder) convertShellValue(out []byte, typeBytePos int) ([]byte, error) {
+	name, err := d.peekIdentifier()
+	if err != nil {
+		return nil, err
+	}
+
+	constructor := string(name)
+	switch constructor {
+	case "null":
+		_, _ = d.json.Discard(1)
+		overwriteTypeByte(out, typeBytePos, bsonNull)
+		return d.convertNull(out)
+	case "ObjectId", "ISODate", "new", "NumberInt", "NumberLong", "NumberDecimal", "BinData", "Timestamp":
+	default:
+		if d.lenient && (constructor == "NaN" || constructor == "Infinity") {
+			return d.convertNonFinite(out, typeBytePos)
+		}
+		return nil, d.parseError(nil, "unknown shell constructor")
+	}
+	_, _ = d.json.Discard(len(name))
+
+	// `new` must be followed by white space and `Date`.
+	if constructor == "new" {
+		ch, err := d.json.ReadByte()
+		if err != nil {
+			return nil, newReadError(err)
+		}
+		switch ch {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return nil, d.parseError([]byte{ch}, "expecting white space after new")
+		}
+		err = d.skipWS()
+		if err != nil {
+			return nil, newReadError(err)
+		}
+		name, err = d.peekIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		if string(name) != "Date" {
+			return nil, d.parseError(nil, "expecting Date after new")
+		}
+		_, _ = d.json.Discard(len(name))
+	}
+
+	err = d.readCharAfterWS('(')
+	if err != nil {
+		return nil, err
+	}
+
+	switch constructor {
+	case "ObjectId":
+		overwriteTypeByte(out, typeBytePos, bsonObjectID)
+		err = d.readQuoteStart()
+		if err != nil {
+			return nil, err
+		}
+		out, err = d.convertOIDString(out)
+	case "ISODate":
+		overwriteTypeByte(out, typeBytePos, bsonDateTime)
+		err = d.readQuoteStart()
+		if err != nil {
+			return nil, err
+		}
+		out, err = d.convertDateString(out)
+	case "new":
+		overwriteTypeByte(out, typeBytePos, bsonDateTime)
+		out, err = d.convertShellDate(out)
+	case "NumberInt":
+		overwriteTypeByte(out, typeBytePos, bsonInt32)
+		out, err = d.convertShellInt(out, 32)
+	case "NumberLong":
+		overwriteTypeByte(out, typeBytePos, bsonInt64)
+		out, err = d.convertShellInt(out, 64)
+	case "NumberDecimal":
+		overwriteTypeByte(out, typeBytePos, bsonDecimal128)
+		err = d.readQuoteStart()
+		if err != nil {
+			return nil, err
+		}
+		out, err = d.convertDecimalString(out)
+	case "BinData":
+		overwriteTypeByte(out, typeBytePos, bsonBinary)
+		out, err = d.convertShellBinary(out)
+	case "Timestamp":
+		overwriteTypeByte(out, typeBytePos, bsonTimestamp)
+		out, err = d.convertShellTimestamp(out)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.readCharAfterWS(')')
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// convertShellDate converts the argument of `new Date(...)`, which may be an
+// ISO-8601 string or milliseconds since the epoch.
+func (d *Decoder) convertShellDate(out []byte) ([]byte, error) {
+	ch, err := d.readAfterWS()
+	if err != nil {
+		return nil, newReadError(err)
+	}
+	switch ch {
+	case '"':
+		return d.convertDateString(out)
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		_ = d.json.UnreadByte()
+		epochMillis, err := d.readInt64()
+		if err != nil {
+			return nil, err
+		}
+		var x [8]byte
+		xs := x[0:8]
+		binary.LittleEndian.PutUint64(xs, uint64(epochMillis))
+		out = append(out, xs...)
+		return out, nil
+	default:
+		return nil, d.parseError([]byte{ch}, "invalid value for Date")
+	}
+}
+
+// convertShellInt converts an integer argument of a given bit size, which may
+// be quoted.
+func (d *Decoder) convertShellInt(out []byte, bitSize int) ([]byte, error) {
+	ch, err := d.readAfterWS()
+	if err != nil {
+		return nil, newReadError(err)
+	}
+	if ch == '"' {
+		if bitSize == 32 {
+			return d.convertInt32String(out)
+		}
+		return d.convertInt64String(out)
+	}
+	_ = d.json.UnreadByte()
+
+	buf, err := d.peekInt64()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseInt(string(buf), 10, bitSize)
+	if err != nil {
+		return nil, d.parseError(nil, fmt.Sprintf("int conversion: %v", err))
+	}
+	_, _ = d.json.Discard(len(buf))
+
+	var x [8]byte
+	xs := x[0:8]
+	binary.LittleEndian.PutUint64(xs, uint64(n))
+	out = append(out, xs[0:bitSize/8]...)
+	return out, nil
+}
+
+// convertShellBinary converts the subtype and base64 payload arguments of
+// `BinData(...)`.
+func (d *Decoder) convertShellBinary(out []byte) ([]byte, error) {
+	// Write a length placeholder and a subtype byte placeholder
+	lengthPos := len(out)
+	out = append(out, emptyLength...)
+	subTypeBytePos := len(out)
+	out = append(out, emptyType)
+
+	err := d.skipWS()
+	if err != nil {
+		return nil, newReadError(err)
+	}
+	n, err := d.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if n > 0xff {
+		return nil, d.parseError(nil, "binary subtype out of range")
+	}
+	subType := byte(n)
+	overwriteTypeByte(out, subTypeBytePos, subType)
+
+	err = d.readCharAfterWS(',')
+	if err != nil {
+		return nil, err
+	}
+	err = d.readQuoteStart()
+	if err != nil {
+		return nil, err
+	}
+	out, err = d.convertBase64(out)
+	if err != nil {
+		return nil, err
+	}
+
+	return writeBinaryLength(out, lengthPos, subType), nil
+}
+
+// convertShellTimestamp converts the seconds and increment arguments of
+// `Timestamp(...)`.
+func (d *Decoder) convertShellTimestamp(out []byte) ([]byte, error) {
+	err := d.skipWS()
+	if err != nil {
+		return nil, newReadError(err)
+	}
+	timestamp, err := d.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	err = d.readCharAfterWS(',')
+	if err != nil {
+		return nil, err
+	}
+	err = d.skipWS()
+	if err != nil {
+		return nil, newReadError(err)
+	}
+	increment, err := d.readUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	// Write increment and timestamp in that order
+	var x [4]byte
+	xs := x[0:4]
+	binary.LittleEndian.PutUint32(xs, increment)
+	out = append(out, xs...)
+	binary.LittleEndian.PutUint32(xs, timestamp)
+	out = append(out, xs...)
+	return out, nil
+}
+
+// convertRegexLiteral starts after the opening slash of a regular expression
+// literal and converts the pattern and flags.
+func (d *Decoder) convertRegexLiteral(out []byte, typeBytePos int) ([]byte, error) {
+	overwriteTypeByte(out, typeBytePos, bsonRegex)
+
+	// The pattern ends at a slash that isn't escaped or in a character class.
+	var escaped, class bool
+	for {
+		ch, err := d.json.ReadByte()
+		if err != nil {
+			return nil, newReadError(err)
+		}
+		if ch < ' ' {
+			_ = d.json.UnreadByte()
+			return nil, d.parseError(nil, "control characters not allowed in regular expressions")
+		}
+		if !escaped && !class && ch == '/' {
+			break
+		}
+		switch {
+		case escaped:
+			escaped = false
+		case ch == '\\':
+			escaped = true
+		case ch == '[':
+			class = true
+		case ch == ']':
+			class = false
+		}
+		out = append(out, ch)
+	}
+	out = append(out, nullByte)
+
+	// Flags are any identifier characters after the pattern.  Keep the
+	// location for error reporting.
+	peekPos := d.json.position()
+	peek := d.copyPeek(parseErrorContextLength)
+	flagsPos := len(out)
+	for {
+		ch, err := d.json.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, newReadError(err)
+		}
+		if !isIdentifierChar(ch) {
+			_ = d.json.UnreadByte()
+			break
+		}
+		out = append(out, ch)
+	}
+	err := sortOptions(out[flagsPos:])
+	if err != nil {
+		return nil, d.parseErrorAt(peekPos, peek, err.Error())
+	}
+	out = append(out, nullByte)
+
+	return out, nil
+}
+
+// skipShellValue starts after the first character of a shell constructor or
+// regular expression and consumes it.  For other identifiers, like `true`, it
+// consumes the identifier.
+func (d *Decoder) skipShellValue(ch byte) error {
+	if ch == '/' {
+		return d.skipRegexLiteral()
+	}
+
+	// Consume names, like `new Date`, up to the opening parenthesis.
+	for {
+		ch, err := d.json.ReadByte()
+		if err != nil {
+			if err == io.EOF && d.curDepth == 0 {
+				return nil
+			}
+			return newReadError(err)
+		}
+		switch {
+		case ch == '(':
+			return d.skipShellArguments()
+		case ch == ',', ch == ':', ch == ']', ch == '}', ch == '/':
+			_ = d.json.UnreadByte()
+			return nil
+		case isIdentifierChar(ch), ch == ' ', ch == '\t', ch == '\n', ch == '\r':
+		default:
+			return d.parseError([]byte{ch}, "invalid character")
+		}
+	}
+}
+
+// skipShellArguments starts after the opening parenthesis of a shell
+// constructor and consumes the arguments, including the closing parenthesis.
+func (d *Decoder) skipShellArguments() error {
+	for {
+		ch, err := d.json.ReadByte()
+		if err != nil {
+			return newReadError(err)
+		}
+		switch ch {
+		case '"':
+			err = d.skipString(ch)
+			if err != nil {
+				return err
+			}
+		case ')':
+			return nil
+		}
+	}
+}
+
+// skipRegexLiteral starts after the opening slash of a regular expression
+// literal and consumes it, including any flags.
+func (d *Decoder) skipRegexLiteral() error {
+	var escaped, class bool
+	for {
+		ch, err := d.json.ReadByte()
+		if err != nil {
+			return newReadError(err)
+		}
+		if !escaped && !class && ch == '/' {
+			break
+		}
+		switch {
+		case escaped:
+			escaped = false
+		case ch == '\\':
+			escaped = true
+		case ch == '[':
+			class = true
+		case ch == ']':
+			class = false
+		}
+	}
+	for {
+		ch, err := d.json.ReadByte()
+		if err != nil {
+			if err == io.EOF && d.curDepth == 0 {
+				return nil
+			}
+			return newReadError(err)
+		}
+		if !isIdentifierChar(ch) {
+			_ = d.json.UnreadByte()
+			return nil
+		}
+	}
+}
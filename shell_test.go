@@ -0,0 +1,210 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestShellMode checks that shell constructors convert to the same BSON as
+// their Extended JSON equivalents.
+func TestShellMode(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label   string
+		input   string
+		extJSON string
+		errStr  string
+		lenient bool
+	}
+
+	cases := []testCase{
+		{
+			label:   "ObjectId",
+			input:   `{"_id": ObjectId("5f0c6f2e8f1b2c3d4e5f6a7b")}`,
+			extJSON: `{"_id":{"$oid":"5f0c6f2e8f1b2c3d4e5f6a7b"}}`,
+		},
+		{
+			label:   "ISODate",
+			input:   `{"a": ISODate( "2020-07-13T12:00:00.123Z" )}`,
+			extJSON: `{"a":{"$date":"2020-07-13T12:00:00.123Z"}}`,
+		},
+		{
+			label:   "new Date",
+			input:   `{"a": new Date("2020-07-13T12:00:00Z"), "b": new  Date(1594641600000), "c": new Date(-1)}`,
+			extJSON: `{"a":{"$date":"2020-07-13T12:00:00Z"},"b":{"$date":{"$numberLong":"1594641600000"}},"c":{"$date":{"$numberLong":"-1"}}}`,
+		},
+		{
+			label:   "NumberInt and NumberLong",
+			input:   `{"a": NumberInt(42), "b": NumberInt("-7"), "c": NumberLong(5), "d": NumberLong("9223372036854775807")}`,
+			extJSON: `{"a":{"$numberInt":"42"},"b":{"$numberInt":"-7"},"c":{"$numberLong":"5"},"d":{"$numberLong":"9223372036854775807"}}`,
+		},
+		{
+			label:   "NumberDecimal",
+			input:   `{"a": NumberDecimal("1.5")}`,
+			extJSON: `{"a":{"$numberDecimal":"1.5"}}`,
+		},
+		{
+			label:   "BinData",
+			input:   `{"a": BinData(4, "c//SZESzTGmQ6OfR38A11A=="), "b": BinData(0, ""), "c": BinData(2,"AQID")}`,
+			extJSON: `{"a":{"$binary":{"base64":"c//SZESzTGmQ6OfR38A11A==","subType":"04"}},"b":{"$binary":{"base64":"","subType":"00"}},"c":{"$binary":{"base64":"AQID","subType":"02"}}}`,
+		},
+		{
+			label:   "Timestamp",
+			input:   `{"a": Timestamp(1594641600, 1)}`,
+			extJSON: `{"a":{"$timestamp":{"t":1594641600,"i":1}}}`,
+		},
+		{
+			label:   "regular expressions",
+			input:   `{"a": /ab+c/, "b": /a\/[/]b/xi, "c": [/x/m]}`,
+			extJSON: `{"a":{"$regularExpression":{"pattern":"ab+c","options":""}},"b":{"$regularExpression":{"pattern":"a\\/[/]b","options":"ix"}},"c":[{"$regularExpression":{"pattern":"x","options":"m"}}]}`,
+		},
+		{
+			label:   "JSON literals",
+			input:   `{"a": null, "b": true, "c": [false, 1]}`,
+			extJSON: `{"a":null,"b":true,"c":[false,1]}`,
+		},
+		{
+			label:   "lenient regex and comments",
+			input:   "{a: /x/i /* comment */, b: Infinity // end\n}",
+			extJSON: `{"a":{"$regularExpression":{"pattern":"x","options":"i"}},"b":{"$numberDouble":"Infinity"}}`,
+			lenient: true,
+		},
+		{
+			label:  "unknown constructor",
+			input:  `{"a": UUID("00000000-0000-0000-0000-000000000000")}`,
+			errStr: "unknown shell constructor",
+		},
+		{
+			label:  "NaN in strict mode",
+			input:  `{"a": NaN}`,
+			errStr: "unknown shell constructor",
+		},
+		{
+			label:  "new without Date",
+			input:  `{"a": new Object()}`,
+			errStr: "expecting Date after new",
+		},
+		{
+			label:  "missing parenthesis",
+			input:  `{"a": NumberLong(5}`,
+			errStr: "expecting ')'",
+		},
+		{
+			label:  "NumberInt out of range",
+			input:  `{"a": NumberInt(2147483648)}`,
+			errStr: "int conversion",
+		},
+		{
+			label:  "bad ObjectId",
+			input:  `{"a": ObjectId("5f0c"), "b": "padding for peek"}`,
+			errStr: "ill-formed $oid",
+		},
+		{
+			label:  "binary subtype out of range",
+			input:  `{"a": BinData(256, "")}`,
+			errStr: "binary subtype out of range",
+		},
+		{
+			label:  "invalid regex flag",
+			input:  `{"a": /x/g}`,
+			errStr: "invalid regular expression option 'g'",
+		},
+		{
+			label:  "unterminated regex",
+			input:  "{\"a\": /x\n/}",
+			errStr: "control characters not allowed in regular expressions",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+
+			jib, err := NewDecoder(bufio.NewReader(strings.NewReader(c.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jib.ShellMode(true)
+			jib.Lenient(c.lenient)
+			got, err := jib.Decode(nil)
+			if c.errStr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errStr) {
+					t.Fatalf("expected error with '%s', but got %v", c.errStr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect, err := UnmarshalExtJSON([]byte(c.extJSON), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, expect) {
+				t.Errorf("expected %s, but got %s", hex.EncodeToString(expect), hex.EncodeToString(got))
+			}
+			_, err = jib.Decode(nil)
+			if err != io.EOF {
+				t.Errorf("expected io.EOF after document, but got %v", err)
+			}
+		})
+	}
+}
+
+// TestShellModeStreamArray checks that skipping values for StreamArray
+// accounts for shell constructors and regular expressions.
+func TestShellModeStreamArray(t *testing.T) {
+	t.Parallel()
+
+	input := `{
+		"ts": Timestamp(1, 2),
+		"when": new Date(0),
+		"re": /[}\]]/,
+		"meta": {"id": ObjectId("5f0c6f2e8f1b2c3d4e5f6a7b"), "list": [/]/i, BinData(0, "")]},
+		"data": [{"a": NumberLong(1)}, {"b": /x/}]
+	}`
+
+	jib, err := NewDecoder(bufio.NewReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jib.ShellMode(true)
+	err = jib.StreamArray("/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := make([]string, 0)
+	for {
+		var buf []byte
+		buf, err = jib.Decode(nil)
+		if err != nil {
+			break
+		}
+		json, err := MarshalExtJSON(buf, nil, RelaxedExtJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output = append(output, string(json))
+	}
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, but got %v", err)
+	}
+	expect := []string{`{"a":1}`, `{"b":{"$regularExpression":{"pattern":"x","options":""}}}`}
+	if !reflect.DeepEqual(output, expect) {
+		t.Errorf("expected %q, but got %q", expect, output)
+	}
+}
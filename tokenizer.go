@@ -0,0 +1,373 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TokenKind identifies the kind of a Token.
+type TokenKind int
+
+// TokenKind constants.
+const (
+	// StartObject is the opening brace of an object.
+	StartObject TokenKind = iota
+	// EndObject is the closing brace of an object.
+	EndObject
+	// StartArray is the opening bracket of an array.
+	StartArray
+	// EndArray is the closing bracket of an array.
+	EndArray
+	// Key is the key of an object element.
+	Key
+	// String is a string value.
+	String
+	// Number is a number value.
+	Number
+	// Bool is a `true` or `false` value.
+	Bool
+	// Null is a `null` value.
+	Null
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case StartObject:
+		return "start object"
+	case EndObject:
+		return "end object"
+	case StartArray:
+		return "start array"
+	case EndArray:
+		return "end array"
+	case Key:
+		return "key"
+	case String:
+		return "string"
+	case Number:
+		return "number"
+	case Bool:
+		return "bool"
+	case Null:
+		return "null"
+	default:
+		return fmt.Sprintf("TokenKind(%d)", int(k))
+	}
+}
+
+// Token is a syntactic element of JSON text.
+type Token struct {
+	// Kind is the kind of the token.
+	Kind TokenKind
+	// Offset is the byte offset in the input stream of the first byte of
+	// the token, counting from the start of the stream, including any byte
+	// order mark.  For a Key or String, it is the offset of the opening
+	// quote.
+	Offset int64
+	// Value is the text of a Key or String, with escapes decoded; the text of
+	// a Number, exactly as in the input; or the literal `true`, `false` or
+	// `null`.  It is nil for the other kinds and is only valid until the
+	// next token is read.
+	Value []byte
+}
+
+// Tokenizer reads a stream of JSON values as tokens, without converting them
+// to BSON.  It uses the same scanner as a Decoder, so it can be the basis for
+// custom transformations of JSON.  Values of any type may be separated by
+// optional white space.  The input must be strict JSON.
+type Tokenizer struct {
+	d     *Decoder
+	stack []byte
+	state tokenizerState
+	buf   []byte
+	err   error
+}
+
+type tokenizerState int
+
+const (
+	expectValue tokenizerState = iota
+	expectValueOrEnd
+	expectKey
+	expectKeyOrEnd
+	afterValue
+)
+
+var (
+	trueLiteral  = []byte("true")
+	falseLiteral = []byte("false")
+	nullLiteral  = []byte("null")
+)
+
+// NewTokenizer returns a new tokenizer.  Like with NewDecoder, a byte order
+// mark is stripped, UTF-16 and UTF-32 input is transcoded to UTF-8, and the
+// bufio.Reader is rebuffered.  Unlike NewDecoder, no input is consumed until
+// the first token is read.  Tokens aren't converted to BSON documents, so
+// their size isn't limited.
+func NewTokenizer(json *bufio.Reader) *Tokenizer {
+	d := newDecoder(json)
+	d.maxDocSize = 0
+	return &Tokenizer{d: d}
+}
+
+// MaxDepth sets the maximum allowed depth of nested objects and arrays.  The
+// default is 200.
+func (t *Tokenizer) MaxDepth(n int) {
+	t.d.maxDepth = n
+}
+
+// Next returns the next token from the input stream.  The function returns
+// io.EOF if the input ends between top-level values.  Malformed JSON returns
+// a ParseError, which has no Path.  After any error, Next returns the same
+// error.
+func (t *Tokenizer) Next() (Token, error) {
+	if t.err != nil {
+		return Token{}, t.err
+	}
+	tok, err := t.next()
+	if err != nil {
+		t.err = err
+	}
+	return tok, err
+}
+
+// Walk calls a function with each token until the input stream ends.  It
+// returns nil at the end of input, or the first error from reading a token
+// or from the function.
+func (t *Tokenizer) Walk(fn func(Token) error) error {
+	for {
+		tok, err := t.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		err = fn(tok)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *Tokenizer) next() (Token, error) {
+	d := t.d
+
+	ch, err := d.readAfterWS()
+	if err != nil {
+		if err == io.EOF && len(t.stack) == 0 {
+			return Token{}, io.EOF
+		}
+		return Token{}, newReadError(err)
+	}
+
+	// Between top-level values, the next character starts a value.
+	if t.state == afterValue && len(t.stack) == 0 {
+		t.state = expectValue
+	}
+
+	// After a value in a container, handle the value-separator or end.
+	if t.state == afterValue {
+		top := t.stack[len(t.stack)-1]
+		switch {
+		case ch == ',':
+			t.state = expectValue
+			if top == '{' {
+				t.state = expectKey
+			}
+			ch, err = d.readAfterWS()
+			if err != nil {
+				return Token{}, newReadError(err)
+			}
+		case ch == '}' && top == '{', ch == ']' && top == '[':
+			return t.end(ch), nil
+		case top == '{':
+			return Token{}, d.parseError([]byte{ch}, "expecting value-separator or end of object")
+		default:
+			return Token{}, d.parseError([]byte{ch}, "expecting value-separator or end of array")
+		}
+	}
+
+	switch t.state {
+	case expectKeyOrEnd:
+		if ch == '}' {
+			return t.end(ch), nil
+		}
+		fallthrough
+	case expectKey:
+		return t.key(ch)
+	case expectValueOrEnd:
+		if ch == ']' {
+			return t.end(ch), nil
+		}
+	}
+
+	if len(t.stack) == 0 {
+		d.docCount++
+		d.startDocument(ch)
+	}
+	return t.value(ch)
+}
+
+// key reads a key and the following name separator.  The character given is
+// the first of the key.
+func (t *Tokenizer) key(ch byte) (Token, error) {
+	d := t.d
+	offset := d.offset() - 1
+	if ch != '"' {
+		return Token{}, d.parseError([]byte{ch}, "expecting key")
+	}
+	var err error
+	t.buf, err = t.readString()
+	if err != nil {
+		return Token{}, err
+	}
+	err = d.readNameSeparator()
+	if err != nil {
+		return Token{}, err
+	}
+	t.state = expectValue
+	return Token{Kind: Key, Offset: offset, Value: t.buf}, nil
+}
+
+// value reads a value or the start of a container.  The character given is
+// the first of the value.
+func (t *Tokenizer) value(ch byte) (Token, error) {
+	d := t.d
+	offset := d.offset() - 1
+	var err error
+
+	switch ch {
+	case '{', '[':
+		if len(t.stack) >= d.maxDepth {
+			return Token{}, errors.New("maximum depth exceeded")
+		}
+		t.stack = append(t.stack, ch)
+		d.curDepth = len(t.stack)
+		if ch == '{' {
+			t.state = expectKeyOrEnd
+			return Token{Kind: StartObject, Offset: offset}, nil
+		}
+		t.state = expectValueOrEnd
+		return Token{Kind: StartArray, Offset: offset}, nil
+	case '"':
+		t.buf, err = t.readString()
+		if err != nil {
+			return Token{}, err
+		}
+		t.state = afterValue
+		return Token{Kind: String, Offset: offset, Value: t.buf}, nil
+	case 't':
+		_, err = d.convertTrue(t.buf[0:0])
+		if err != nil {
+			return Token{}, err
+		}
+		t.state = afterValue
+		return Token{Kind: Bool, Offset: offset, Value: trueLiteral}, nil
+	case 'f':
+		_, err = d.convertFalse(t.buf[0:0])
+		if err != nil {
+			return Token{}, err
+		}
+		t.state = afterValue
+		return Token{Kind: Bool, Offset: offset, Value: falseLiteral}, nil
+	case 'n':
+		_, err = d.convertNull(t.buf[0:0])
+		if err != nil {
+			return Token{}, err
+		}
+		t.state = afterValue
+		return Token{Kind: Null, Offset: offset, Value: nullLiteral}, nil
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		_ = d.json.UnreadByte()
+		num, _, err := d.peekNumber()
+		if err != nil {
+			return Token{}, err
+		}
+		if !isJSONNumber(num) {
+			return Token{}, d.parseError(nil, "invalid number")
+		}
+		t.buf = append(t.buf[0:0], num...)
+		_, _ = d.json.Discard(len(num))
+		t.state = afterValue
+		return Token{Kind: Number, Offset: offset, Value: t.buf}, nil
+	default:
+		return Token{}, d.parseError([]byte{ch}, "invalid character")
+	}
+}
+
+// readString reads a string after its opening quote into the token buffer,
+// without the null terminator of a C string.
+func (t *Tokenizer) readString() ([]byte, error) {
+	buf, err := t.d.convertCString(t.buf[0:0])
+	// A null byte is allowed, since the string isn't converted to BSON.
+	if err != nil && err != errNullEscape {
+		return nil, err
+	}
+	return buf[0 : len(buf)-1], nil
+}
+
+// end closes the current container.  The character given is its closing
+// brace or bracket.
+func (t *Tokenizer) end(ch byte) Token {
+	t.stack = t.stack[0 : len(t.stack)-1]
+	t.d.curDepth = len(t.stack)
+	t.state = afterValue
+	kind := EndObject
+	if ch == ']' {
+		kind = EndArray
+	}
+	return Token{Kind: kind, Offset: t.d.offset() - 1}
+}
+
+// isJSONNumber reports whether a number found by peekNumber has the form of a
+// JSON number.  It need not check for a leading zero.
+func isJSONNumber(num []byte) bool {
+	i := 0
+	if i < len(num) && num[i] == '-' {
+		i++
+	}
+	start := i
+	for i < len(num) && isDigit(num[i]) {
+		i++
+	}
+	if i == start {
+		return false
+	}
+	if i < len(num) && num[i] == '.' {
+		i++
+		start = i
+		for i < len(num) && isDigit(num[i]) {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+	if i < len(num) && (num[i] == 'e' || num[i] == 'E') {
+		i++
+		if i < len(num) && (num[i] == '-' || num[i] == '+') {
+			i++
+		}
+		start = i
+		for i < len(num) && isDigit(num[i]) {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+	return i == len(num)
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
@@ -0,0 +1,205 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// formatToken describes a token as kind, offset and any value.
+func formatToken(tok Token) string {
+	if tok.Value == nil {
+		return fmt.Sprintf("%s@%d", tok.Kind, tok.Offset)
+	}
+	return fmt.Sprintf("%s@%d:%s", tok.Kind, tok.Offset, tok.Value)
+}
+
+func TestTokenizer(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		label  string
+		input  string
+		output []string
+		errStr string
+	}
+
+	cases := []testCase{
+		{
+			label: "object",
+			input: `{"a": [1, -2.5e3, "x\ty"], "b": {}, "c": [], "d": true, "e": false, "f": null}`,
+			output: []string{
+				"start object@0", "key@1:a", "start array@6", "number@7:1", "number@10:-2.5e3",
+				"string@18:x\ty", "end array@24", "key@27:b", "start object@32", "end object@33",
+				"key@36:c", "start array@41", "end array@42", "key@45:d", "bool@50:true",
+				"key@56:e", "bool@61:false", "key@68:f", "null@73:null", "end object@77",
+			},
+		},
+		{
+			label:  "stream of values",
+			input:  "1 \"a\"\n[null] {}",
+			output: []string{"number@0:1", "string@2:a", "start array@6", "null@7:null", "end array@11", "start object@13", "end object@14"},
+		},
+		{
+			label:  "empty input",
+			input:  "  ",
+			output: []string{},
+		},
+		{
+			label:  "escaped key",
+			input:  `{"\u00e9\"":0}`,
+			output: []string{"start object@0", "key@1:é\"", "number@12:0", "end object@13"},
+		},
+		{
+			label:  "mismatched end",
+			input:  `{"a":[1}`,
+			errStr: "expecting value-separator or end of array",
+		},
+		{
+			label:  "missing key",
+			input:  `{"a":1,}`,
+			errStr: "expecting key",
+		},
+		{
+			label:  "missing name separator",
+			input:  `{"a" 1}`,
+			errStr: "expecting ':'",
+		},
+		{
+			label:  "bad literal",
+			input:  `[tru]`,
+			errStr: "expecting true",
+		},
+		{
+			label:  "bad number",
+			input:  `[1e]`,
+			errStr: "invalid number",
+		},
+		{
+			label:  "leading zero",
+			input:  `[01]`,
+			errStr: "leading zeros not allowed",
+		},
+		{
+			label:  "invalid character",
+			input:  `[NaN]`,
+			errStr: "invalid character",
+		},
+		{
+			label:  "unclosed array",
+			input:  `[1, 2`,
+			errStr: "unexpected EOF",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+
+			tok := NewTokenizer(bufio.NewReader(strings.NewReader(c.input)))
+			output := make([]string, 0)
+			err := tok.Walk(func(tk Token) error {
+				output = append(output, formatToken(tk))
+				return nil
+			})
+			if c.errStr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errStr) {
+					t.Fatalf("expected error with '%s', but got %v", c.errStr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(output, c.output) {
+				t.Errorf("expected %q, but got %q", c.output, output)
+			}
+		})
+	}
+}
+
+func TestTokenizerNext(t *testing.T) {
+	t.Parallel()
+
+	tok := NewTokenizer(bufio.NewReader(strings.NewReader(`{"a":1} [2,]`)))
+	expect := []TokenKind{StartObject, Key, Number, EndObject, StartArray, Number}
+	for i, kind := range expect {
+		tk, err := tok.Next()
+		if err != nil {
+			t.Fatalf("token %d: %v", i, err)
+		}
+		if tk.Kind != kind {
+			t.Fatalf("token %d: expected %s, but got %s", i, kind, tk.Kind)
+		}
+	}
+
+	_, err := tok.Next()
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected *ParseError, but got %v", err)
+	}
+	if pe.Offset != 11 || pe.Document != 1 {
+		t.Errorf("expected offset 11 in document 1, but got offset %d in document %d", pe.Offset, pe.Document)
+	}
+
+	// Errors are sticky.
+	_, err2 := tok.Next()
+	if err2 != err {
+		t.Errorf("expected the same error again, but got %v", err2)
+	}
+
+	// A callback error stops Walk.
+	stop := errors.New("stop")
+	tok = NewTokenizer(bufio.NewReader(strings.NewReader(`[1,2]`)))
+	var count int
+	err = tok.Walk(func(tk Token) error {
+		count++
+		if tk.Kind == Number {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || count != 2 {
+		t.Errorf("expected stop after 2 tokens, but got %v after %d", err, count)
+	}
+	_, err = tok.Next()
+	if err != nil {
+		t.Errorf("expected next token after callback error, but got %v", err)
+	}
+
+	// Depth is limited.
+	tok = NewTokenizer(bufio.NewReader(strings.NewReader(`[[[]]]`)))
+	tok.MaxDepth(2)
+	err = tok.Walk(func(Token) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "maximum depth exceeded") {
+		t.Errorf("expected depth error, but got %v", err)
+	}
+
+	// Strings aren't limited to the maximum document size.
+	long := strings.Repeat("x", defaultMaxDocumentSize+1<<16)
+	tok = NewTokenizer(bufio.NewReader(strings.NewReader(`"` + long + `"`)))
+	tk, err := tok.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Kind != String || string(tk.Value) != long {
+		t.Errorf("expected String with %d bytes, but got %s with %d bytes", len(long), tk.Kind, len(tk.Value))
+	}
+
+	tok = NewTokenizer(bufio.NewReader(strings.NewReader(``)))
+	_, err = tok.Next()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, but got %v", err)
+	}
+}
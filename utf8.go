@@ -0,0 +1,47 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+// UTF8Policy determines how a Decoder handles invalid UTF-8 in strings and
+// keys.
+type UTF8Policy int
+
+// UTF8Policy constants.
+const (
+	// AllowInvalidUTF8 copies strings and keys without checking them.  This
+	// is the default.
+	AllowInvalidUTF8 UTF8Policy = iota
+	// ErrorOnInvalidUTF8 returns a ParseError at the first invalid byte.
+	ErrorOnInvalidUTF8
+	// ReplaceInvalidUTF8 writes the Unicode replacement character, U+FFFD,
+	// for each invalid byte.
+	ReplaceInvalidUTF8
+)
+
+// InvalidUTF8 sets the policy for invalid UTF-8 in strings and keys.  By
+// default, input is expected to be well-formed and is not checked.  With other
+// policies, non-ASCII characters are checked as they are copied, so ASCII text
+// costs no more to convert.
+func (d *Decoder) InvalidUTF8(policy UTF8Policy) {
+	d.invalidUTF8 = policy
+}
+
+// replacementChar is U+FFFD encoded as UTF-8.
+const replacementChar = "\uFFFD"
+
+// utf8SequenceLength returns the length of a UTF-8 sequence from its first
+// byte, which must start a multi-byte sequence.
+func utf8SequenceLength(b byte) int {
+	switch {
+	case b < 0xE0:
+		return 2
+	case b < 0xF0:
+		return 3
+	default:
+		return 4
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 by David A. Golden. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package jibby
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// The seriot.ch corpus files with invalid UTF-8 in strings.
+var invalidUTF8Tests = []string{
+	"i_string_UTF-8_invalid_sequence.json",
+	"i_string_UTF8_surrogate_U+D800.json",
+	"i_string_invalid_utf-8.json",
+	"i_string_iso_latin_1.json",
+	"i_string_lone_utf8_continuation_byte.json",
+	"i_string_not_in_unicode_range.json",
+	"i_string_overlong_sequence_2_bytes.json",
+	"i_string_overlong_sequence_6_bytes.json",
+	"i_string_overlong_sequence_6_bytes_null.json",
+	"i_string_truncated-utf-8.json",
+}
+
+func decodeWithUTF8Policy(input []byte, policy UTF8Policy) ([]byte, error) {
+	jib, err := NewDecoder(bufio.NewReader(bytes.NewReader(input)))
+	if err != nil {
+		return nil, err
+	}
+	jib.InvalidUTF8(policy)
+	return jib.Decode(nil)
+}
+
+// TestInvalidUTF8_JSONTestSuite checks that invalid UTF-8 in the seriot.ch
+// corpus is detected or replaced.
+func TestInvalidUTF8_JSONTestSuite(t *testing.T) {
+	t.Parallel()
+
+	for _, f := range invalidUTF8Tests {
+		f := f
+		t.Run(f, func(t *testing.T) {
+			t.Parallel()
+			text, err := ioutil.ReadFile(filepath.Join(JSONTestSuite, f))
+			if err != nil {
+				t.Fatalf("error reading %s: %v", f, err)
+			}
+			text = objectify(text)
+
+			_, err = decodeWithUTF8Policy(text, ErrorOnInvalidUTF8)
+			if err == nil || !strings.Contains(err.Error(), "invalid UTF-8") {
+				t.Errorf("expected invalid UTF-8 error, but got %v", err)
+			}
+
+			bson, err := decodeWithUTF8Policy(text, ReplaceInvalidUTF8)
+			if err != nil {
+				t.Fatalf("error with replacement: %v", err)
+			}
+			json, err := Marshal(bson, nil)
+			if err != nil {
+				t.Fatalf("error marshaling output: %v", err)
+			}
+			if !bytes.Contains(json, []byte(replacementChar)) {
+				t.Errorf("expected replacement character in output, but got %q", json)
+			}
+		})
+	}
+}
+
+// TestInvalidUTF8 checks UTF-8 policies for keys and strings, including
+// characters split across peek chunks.
+func TestInvalidUTF8(t *testing.T) {
+	t.Parallel()
+
+	// Puts the second byte of 'é' at the start of the second 64 byte chunk.
+	long := strings.Repeat("x", 62)
+
+	type testCase struct {
+		label     string
+		input     string
+		policy    UTF8Policy
+		output    string
+		errStr    string
+		errOffset int64
+	}
+
+	cases := []testCase{
+		{
+			label:  "allow",
+			input:  "{\"a\":\"b\xffc\"}",
+			policy: AllowInvalidUTF8,
+		},
+		{
+			label:  "valid multi-byte",
+			input:  `{"é":"日本語 𝄞"}`,
+			policy: ErrorOnInvalidUTF8,
+			output: `{"é":"日本語 𝄞"}`,
+		},
+		{
+			label:  "split across chunks",
+			input:  `{"a":"` + long + `é𝄞"}`,
+			policy: ErrorOnInvalidUTF8,
+			output: `{"a":"` + long + `é𝄞"}`,
+		},
+		{
+			label:     "error in string",
+			input:     "{\"a\":\"b\xffc\"}",
+			policy:    ErrorOnInvalidUTF8,
+			errStr:    "invalid UTF-8",
+			errOffset: 7,
+		},
+		{
+			label:     "error in key",
+			input:     "{\"a\xc3\":1}",
+			policy:    ErrorOnInvalidUTF8,
+			errStr:    "invalid UTF-8",
+			errOffset: 3,
+		},
+		{
+			label:     "error after chunk",
+			input:     `{"a":"` + long + "\xe9\"}",
+			policy:    ErrorOnInvalidUTF8,
+			errStr:    "invalid UTF-8",
+			errOffset: 68,
+		},
+		{
+			label:  "replace in string",
+			input:  "{\"a\":\"b\xed\xa0\x80c\"}",
+			policy: ReplaceInvalidUTF8,
+			output: `{"a":"b` + strings.Repeat(replacementChar, 3) + `c"}`,
+		},
+		{
+			label:  "replace in key",
+			input:  "{\"a\xc3\":1}",
+			policy: ReplaceInvalidUTF8,
+			output: `{"a` + replacementChar + `":1}`,
+		},
+		{
+			label:  "truncated at end",
+			input:  "{\"a\":\"\xe6\x97",
+			policy: ReplaceInvalidUTF8,
+			errStr: "unexpected EOF",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			t.Parallel()
+			bson, err := decodeWithUTF8Policy([]byte(c.input), c.policy)
+			if c.errStr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errStr) {
+					t.Fatalf("expected error with '%s', but got %v", c.errStr, err)
+				}
+				var pe *ParseError
+				if c.errOffset == 0 {
+					return
+				}
+				if !errors.As(err, &pe) {
+					t.Fatalf("expected ParseError, but got %T", err)
+				}
+				if pe.Offset != c.errOffset {
+					t.Errorf("expected error at offset %d, but got %d", c.errOffset, pe.Offset)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Invalid UTF-8 is copied as is, but can't be marshaled.
+			if c.policy == AllowInvalidUTF8 {
+				if !bytes.Contains(bson, []byte("b\xffc")) {
+					t.Errorf("expected invalid UTF-8 copied to output, but got %q", bson)
+				}
+				return
+			}
+			json, err := Marshal(bson, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !utf8.Valid(json) {
+				t.Errorf("output is not valid UTF-8: %q", json)
+			}
+			if string(json) != c.output {
+				t.Errorf("expected %s, but got %s", c.output, json)
+			}
+		})
+	}
+}
+
+// TestValidUTF8AtEOF checks a multi-byte character that starts at the end of a
+// 64 byte chunk and is followed only by the closing quote at the end of input.
+func TestValidUTF8AtEOF(t *testing.T) {
+	t.Parallel()
+
+	for _, char := range []string{"é", "日", "𝄞"} {
+		input := `"` + strings.Repeat("x", 63) + char + `"`
+		jib, err := NewDecoder(bufio.NewReader(strings.NewReader(input)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		jib.InvalidUTF8(ErrorOnInvalidUTF8)
+		_, got, err := jib.DecodeValue(nil)
+		if err != nil {
+			t.Fatalf("%s: %v", char, err)
+		}
+		if !bytes.HasSuffix(got, []byte(char+"\x00")) {
+			t.Errorf("%s: expected string ending with the character, but got %q", char, got)
+		}
+	}
+}